@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var filesArchived = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "qc_files_archived_total",
+	Help: "Total number of uploads moved to the cold storage tier",
+})
+
+func init() {
+	prometheus.MustRegister(filesArchived)
+}
+
+// Storage tiers recorded in jobs.storage_tier. The key in jobs.path is the
+// same in both; the tier says which backend holds it.
+const (
+	tierHot  = "hot"
+	tierCold = "cold"
+)
+
+// runArchiver moves uploads of jobs finished more than ARCHIVE_DAYS ago from
+// the main storage to the cold tier. It does nothing unless both
+// ARCHIVE_DAYS and COLD_STORAGE_BACKEND are set.
+func runArchiver(cold Storage) {
+	days, err := strconv.Atoi(env("ARCHIVE_DAYS", "0"))
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid ARCHIVE_DAYS, archiving disabled")
+		return
+	}
+	if days <= 0 || cold == nil {
+		return
+	}
+	interval, err := time.ParseDuration(env("ARCHIVE_SWEEP_INTERVAL", "1h"))
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid ARCHIVE_SWEEP_INTERVAL, using 1h")
+		interval = time.Hour
+	}
+	age := time.Duration(days) * 24 * time.Hour
+	for {
+		for {
+			picked, err := archiveOne(cold, age)
+			if err != nil {
+				log.Error().Err(err).Msg("archiver error")
+			}
+			if !picked {
+				break
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// archiveRetryAfter is how long a job whose archiving failed, or is in
+// progress on another replica, is left alone before it's tried again.
+const archiveRetryAfter = 24 * time.Hour
+
+// archiveOne claims one eligible upload, copies it to cold storage, flips
+// its tier and then removes the hot copy. It reports whether it picked a
+// job, so the sweep moves past one that fails: the claim, recorded in
+// archive_attempted_at, keeps this and other replicas off the job for
+// archiveRetryAfter. No transaction is held during the copy; a crash before
+// the delete only leaves a stray hot copy.
+func archiveOne(cold Storage, age time.Duration) (bool, error) {
+	var id, key string
+	err := db.QueryRow(`
+UPDATE jobs SET archive_attempted_at=now()
+WHERE id = (
+  SELECT id FROM jobs
+  WHERE status='done' AND storage_tier='hot' AND file_deleted_at IS NULL AND path IS NOT NULL AND owns_file
+    AND completed_at < now() - $1::interval
+    AND (archive_attempted_at IS NULL OR archive_attempted_at < now() - $2::interval)
+  ORDER BY completed_at
+  LIMIT 1
+  FOR UPDATE SKIP LOCKED)
+RETURNING id, path`, age.String(), archiveRetryAfter.String()).Scan(&id, &key)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ctx := context.Background()
+	src, err := store.Open(ctx, key)
+	if err != nil {
+		return true, fmt.Errorf("job %s: %w", id, err)
+	}
+	err = cold.Put(ctx, key, src)
+	src.Close()
+	if err != nil {
+		return true, fmt.Errorf("job %s: %w", id, err)
+	}
+	res, err := db.Exec(`UPDATE jobs SET storage_tier=$2, archived_at=now()
+WHERE id=$1 AND storage_tier='hot' AND file_deleted_at IS NULL`, id, tierCold)
+	if err != nil {
+		return true, fmt.Errorf("job %s: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// the file was deleted (cancel, JOB_TTL) while it was being copied
+		if err := cold.Delete(ctx, key); err != nil {
+			log.Warn().Err(err).Str("job_id", id).Msg("failed to delete cold copy of a deleted upload")
+		}
+		return true, nil
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		log.Warn().Err(err).Str("job_id", id).Msg("failed to delete hot copy after archiving")
+	}
+	filesArchived.Inc()
+	log.Info().Str("job_id", id).Msg("archived upload to cold storage")
+	return true, nil
+}
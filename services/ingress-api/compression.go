@@ -0,0 +1,17 @@
+package main
+
+import "bytes"
+
+// sniffCompression classifies an upload from its first bytes the same way
+// the worker's detectCompression does, so the worker can skip sniffing.
+func sniffCompression(sample []byte) string {
+	if len(sample) < 3 || !bytes.Equal(sample[:3], []byte{0x1f, 0x8b, 0x08}) {
+		return "none"
+	}
+	const fextra = 0x04
+	// gzip header (10) + XLEN (2) + first subfield id (2): bgzip's "BC"
+	if len(sample) >= 14 && sample[3]&fextra != 0 && sample[12] == 'B' && sample[13] == 'C' {
+		return "bgzf"
+	}
+	return "gzip"
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// queueDepth is the GET /queue/depth snapshot. BrokerMessages is what
+// RabbitMQ reports as ready on the job queue; it's null when the broker
+// couldn't be asked.
+type queueDepth struct {
+	Queued         int64  `json:"queued"`
+	Retrying       int64  `json:"retrying"`
+	Processing     int64  `json:"processing"`
+	DoneLastHour   int64  `json:"done_last_hour"`
+	BrokerMessages *int   `json:"broker_messages"`
+	AsOf           string `json:"as_of"`
+}
+
+// depthCacheTTL keeps an incident's worth of curl loops off the database.
+const depthCacheTTL = 5 * time.Second
+
+var depthCache struct {
+	mu  sync.Mutex
+	at  time.Time
+	val *queueDepth
+}
+
+func handleQueueDepth(w http.ResponseWriter, r *http.Request) {
+	depthCache.mu.Lock()
+	defer depthCache.mu.Unlock()
+	if depthCache.val == nil || time.Since(depthCache.at) > depthCacheTTL {
+		d, err := loadQueueDepth()
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		depthCache.val, depthCache.at = d, time.Now()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(depthCache.val)
+}
+
+func loadQueueDepth() (*queueDepth, error) {
+	d := &queueDepth{AsOf: time.Now().UTC().Format(time.RFC3339)}
+	err := db.QueryRow(`
+SELECT count(*) FILTER (WHERE status='queued'),
+       count(*) FILTER (WHERE status='retrying'),
+       count(*) FILTER (WHERE status='processing'),
+       count(*) FILTER (WHERE status='done' AND completed_at > now() - interval '1 hour')
+FROM jobs
+WHERE status IN ('queued','retrying','processing')
+   OR (status='done' AND completed_at > now() - interval '1 hour')`).
+		Scan(&d.Queued, &d.Retrying, &d.Processing, &d.DoneLastHour)
+	if err != nil {
+		return nil, err
+	}
+
+	// a separate channel: a failed passive declare closes the channel it ran on,
+	// and the outbox publisher shares amqpCh
+	ch, err := amqpConn.Channel()
+	if err != nil {
+		log.Warn().Err(err).Msg("queue depth: broker channel error")
+		return d, nil
+	}
+	defer ch.Close()
+	q, err := ch.QueueDeclarePassive(queueName, true, false, false, false, nil)
+	if err != nil {
+		log.Warn().Err(err).Msg("queue depth: broker inspect error")
+		return d, nil
+	}
+	d.BrokerMessages = &q.Messages
+	return d, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math"
+)
+
+// estimateSampleBytes is how much of the upload's start is kept to estimate
+// the read count from.
+const estimateSampleBytes = 1 << 20
+
+// sampleWriter keeps the first max bytes written to it.
+type sampleWriter struct {
+	buf []byte
+	max int
+}
+
+func (s *sampleWriter) Write(p []byte) (int, error) {
+	if room := s.max - len(s.buf); room > 0 {
+		s.buf = append(s.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// estimateReads extrapolates a read count from the bytes per record in the
+// sample, scaled up to the whole upload. Gzipped uploads are scaled by the
+// sample's own compression ratio. It returns nil when the sample holds no
+// complete record. This is only a rough guide; the worker's count is exact.
+func estimateReads(sample []byte, size int64) *int64 {
+	text, ratio := sample, 1.0
+	if bytes.HasPrefix(sample, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(sample))
+		if err != nil {
+			return nil
+		}
+		// the sample ends mid-stream, so a truncation error is expected
+		text, _ = io.ReadAll(io.LimitReader(zr, 32*estimateSampleBytes))
+		ratio = float64(len(text)) / float64(len(sample))
+	}
+
+	// records and the bytes they span, ignoring the partial one at the end
+	var records, spanned int
+	if bytes.HasPrefix(bytes.TrimLeft(text, " \t\r\n"), []byte(">")) {
+		// FASTA: sequence lines vary, so count headers
+		records = bytes.Count(text, []byte("\n>"))
+		spanned = bytes.LastIndex(text, []byte("\n>")) + 1
+	} else {
+		lines := bytes.Count(text, []byte("\n"))
+		records = lines / 4
+		spanned = len(text) * (records * 4) / max(lines, 1)
+	}
+	if records == 0 {
+		return nil
+	}
+	perRecord := float64(spanned) / float64(records)
+	n := int64(math.Round(float64(size) * ratio / perRecord))
+	return &n
+}
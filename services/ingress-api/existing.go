@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errKeyInUse is returned by submission.enqueue when an existing object's
+// key is already a job's path, be it another submit's or an upload's.
+var errKeyInUse = errors.New("storage_key is already used by another job")
+
+// existingKey turns a submitted storage_key into a key of our storage: a
+// relative key as recorded in jobs.path, or for the s3 backend an
+// s3://bucket/key URI naming our bucket and, if S3_PREFIX is set, a key
+// under it. Keys that would leave the storage root are refused.
+func existingKey(raw string) (string, error) {
+	key := raw
+	if rest, ok := strings.CutPrefix(raw, "s3://"); ok {
+		s, ok := store.(*s3Storage)
+		bucket, k, _ := strings.Cut(rest, "/")
+		if !ok || bucket != s.bucket {
+			return "", fmt.Errorf("storage_key must be in our bucket")
+		}
+		if s.prefix != "" {
+			if k, ok = strings.CutPrefix(k, strings.TrimSuffix(s.prefix, "/")+"/"); !ok {
+				return "", fmt.Errorf("storage_key must be under S3_PREFIX")
+			}
+		}
+		key = k
+	}
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return "", fmt.Errorf("storage_key must be a relative key like runs/S1_R1.fastq.gz")
+	}
+	return key, nil
+}
+
+// handleSubmitExisting creates a job for an object an upstream process has
+// already put in our storage, so nothing is uploaded: the JSON body holds
+// storage_key, an optional filename (default: the key's last element) and
+// the usual submit options. The object is checked with Storage.Stat and its
+// start read for the compression and read estimate, as for an upload. The
+// job doesn't own the object: cancelling and archiving leave it in place,
+// delete_after_qc is refused, and a key another job already references is
+// rejected with 409.
+func handleSubmitExisting(w http.ResponseWriter, r *http.Request) {
+	if queueUnavailable() {
+		writeQueueUnavailable(w)
+		return
+	}
+	fields, ok := readJSONFields(w, r)
+	if !ok {
+		return
+	}
+	key, err := existingKey(fields["storage_key"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filename := fields["filename"]
+	if filename == "" {
+		filename = path.Base(key)
+	}
+	if err := checkExtension(filename); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	sub := newSubmission()
+	if err := sub.parseOptions(func(k string) string { return fields[k] }); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sub.deleteAfter {
+		http.Error(w, "delete_after_qc can't be used with storage_key: the object isn't the job's", http.StatusBadRequest)
+		return
+	}
+	if err := sub.parseMetadata(fields); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	size, err := store.Stat(r.Context(), key)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "storage_key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("storage stat error")
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+	sub.filename, sub.key, sub.size, sub.external = filename, key, size, true
+	sub.sampleExisting(r.Context())
+	if err := sub.enqueue(); err != nil {
+		if err == errKeyInUse {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	log.Info().Str("job_id", sub.jobID).Str("key", key).Msg("job submitted for existing object")
+	writeSubmitted(w, sub)
+}
+
+// sampleExisting reads the start of an existing object for what store
+// derives from an upload's first bytes. It's best effort: the object was
+// just found, and the worker reads it in full anyway.
+func (s *submission) sampleExisting(ctx context.Context) {
+	f, err := store.Open(ctx, s.key)
+	if err != nil {
+		log.Warn().Err(err).Str("job_id", s.jobID).Msg("could not sample existing object")
+		return
+	}
+	defer f.Close()
+	sample, err := io.ReadAll(io.LimitReader(f, estimateSampleBytes))
+	if err != nil {
+		log.Warn().Err(err).Str("job_id", s.jobID).Msg("could not sample existing object")
+		return
+	}
+	s.detected = sniffCompression(sample)
+	s.estimatedReads = estimateReads(sample, s.size)
+}
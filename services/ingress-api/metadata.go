@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxMetadataBytes (METADATA_MAX_BYTES) caps a job's metadata as stored.
+var maxMetadataBytes int
+
+// parseMetadata collects the caller's sample metadata (sample_id, project,
+// operator, ...) from values: a JSON object in "metadata" plus any meta_<key>
+// fields, which are added as strings and win over the same key in the
+// object. The result is stored in jobs.metadata and echoed by results-api;
+// the service doesn't interpret it.
+func (s *submission) parseMetadata(values map[string]string) error {
+	meta := map[string]any{}
+	if v := values["metadata"]; v != "" {
+		dec := json.NewDecoder(strings.NewReader(v))
+		dec.UseNumber()
+		if err := dec.Decode(&meta); err != nil || meta == nil || dec.More() {
+			return fmt.Errorf("metadata must be a JSON object")
+		}
+	}
+	for k, v := range values {
+		if key, ok := strings.CutPrefix(k, "meta_"); ok && key != "" {
+			meta[key] = v
+		}
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(meta); err != nil {
+		return fmt.Errorf("metadata: %v", err)
+	}
+	if buf.Len() > maxMetadataBytes {
+		return fmt.Errorf("metadata is larger than %d bytes (METADATA_MAX_BYTES)", maxMetadataBytes)
+	}
+	s.metadata = bytes.TrimRight(buf.Bytes(), "\n")
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// statusClientClosedRequest is nginx's 499: the client went away before the
+// answer. Nobody reads it, but it keeps disconnects apart from bad requests
+// in access logs.
+const statusClientClosedRequest = 499
+
+// formBody remembers the first error reading the request body, or a part of
+// it. A multipart error alone can't tell a client that hung up mid-upload
+// from a complete body with a broken form in it, nor a broken file part from
+// a storage failure; these can.
+type formBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *formBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// disconnected reports whether the upload stopped because the client did.
+func (b *formBody) disconnected(r *http.Request) bool {
+	return b.err != nil || r.Context().Err() != nil
+}
+
+// writeFormError answers a multipart parsing failure: 499 when the client
+// disconnected, otherwise 400 saying what was wrong with the form. Oversized
+// fields and uploads over quota get their 413 before this.
+func writeFormError(w http.ResponseWriter, r *http.Request, body *formBody, err error) {
+	if body.disconnected(r) {
+		log.Info().Err(err).Msg("client disconnected during upload")
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		http.Error(w, "invalid form: body ended inside a part (missing closing boundary?)", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "invalid form: "+err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// uploadNameTemplate (UPLOAD_NAME_TEMPLATE) builds the storage key of each
+// upload from {jobid}, {date} (UTC, YYYY-MM-DD), {sha8} (first 8 hex digits
+// of the SHA-256) and {name} (the uploaded file name). A "/" makes
+// subdirectories (or S3 key prefixes), e.g. "{date}/{jobid}_{name}".
+var uploadNameTemplate = defaultNameTemplate
+
+const defaultNameTemplate = "{jobid}_{name}"
+
+var placeholderRe = regexp.MustCompile(`\{[^{}]*\}`)
+
+var namePlaceholders = map[string]bool{"{jobid}": true, "{date}": true, "{sha8}": true, "{name}": true}
+
+// checkNameTemplate rejects templates that could collide across jobs or
+// produce keys outside the storage root.
+func checkNameTemplate(t string) error {
+	for _, p := range placeholderRe.FindAllString(t, -1) {
+		if !namePlaceholders[p] {
+			return fmt.Errorf("UPLOAD_NAME_TEMPLATE: unknown placeholder %s", p)
+		}
+	}
+	if !strings.Contains(t, "{jobid}") {
+		return fmt.Errorf("UPLOAD_NAME_TEMPLATE must contain {jobid} so uploads never collide")
+	}
+	if strings.HasPrefix(t, "/") {
+		return fmt.Errorf("UPLOAD_NAME_TEMPLATE must be relative to UPLOAD_DIR")
+	}
+	for _, seg := range strings.Split(t, "/") {
+		if seg == ".." || seg == "." || seg == "" {
+			return fmt.Errorf("UPLOAD_NAME_TEMPLATE has an empty, . or .. path segment")
+		}
+	}
+	return nil
+}
+
+// needsHash reports whether the key can only be known once the upload has
+// been hashed, in which case it's stored under a provisional key first.
+func needsHash(t string) bool {
+	return strings.Contains(t, "{sha8}")
+}
+
+// uploadKey renders the template. sha may be empty when the template has no
+// {sha8}. The result is cleaned; localStorage still refuses keys that
+// resolve outside UPLOAD_DIR.
+func uploadKey(t, jobID, filename, sha string, now time.Time) string {
+	if len(sha) > 8 {
+		sha = sha[:8]
+	}
+	return path.Clean(strings.NewReplacer(
+		"{jobid}", jobID,
+		"{date}", now.UTC().Format("2006-01-02"),
+		"{sha8}", sha,
+		"{name}", filename,
+	).Replace(t))
+}
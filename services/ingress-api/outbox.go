@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+// publishTimeout (PUBLISH_TIMEOUT) bounds a single publish attempt and
+// publishRetries (PUBLISH_RETRIES) is how many more attempts a message gets,
+// with a short backoff, before the drain stops until the next tick.
+var (
+	publishTimeout = 5 * time.Second
+	publishRetries = 2
+)
+
+// publishUnavailableAfter (PUBLISH_UNAVAILABLE_AFTER) makes submits answer
+// 503 once publishing has failed for that long; 0 keeps accepting jobs into
+// the outbox however long the broker is away.
+var publishUnavailableAfter time.Duration
+
+// publishFailingSince is the UnixNano of the first failed publish of the
+// current outage, or 0 while publishing works.
+var publishFailingSince atomic.Int64
+
+// queueUnavailable reports whether the broker has been unreachable for
+// longer than PUBLISH_UNAVAILABLE_AFTER.
+func queueUnavailable() bool {
+	since := publishFailingSince.Load()
+	return publishUnavailableAfter > 0 && since != 0 && time.Since(time.Unix(0, since)) > publishUnavailableAfter
+}
+
+// outboxWake nudges the publisher after a submit so messages go out right
+// away instead of waiting for the next poll tick.
+var outboxWake = make(chan struct{}, 1)
+
+func enqueueOutbox(tx *sql.Tx, jobID string, body []byte) error {
+	if err := setExpiry(tx, jobID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO outbox (job_id, payload) VALUES ($1,$2)`, jobID, body)
+	return err
+}
+
+func notifyOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// runOutboxPublisher drains the outbox table to RabbitMQ. Rows are deleted
+// only after a successful publish, so every committed job eventually gets a
+// message (at-least-once; the worker tolerates duplicates via the upsert).
+func runOutboxPublisher() {
+	interval, err := time.ParseDuration(env("OUTBOX_POLL_INTERVAL", "2s"))
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid OUTBOX_POLL_INTERVAL, using 2s")
+		interval = 2 * time.Second
+	}
+	if publishTimeout, err = time.ParseDuration(env("PUBLISH_TIMEOUT", "5s")); err != nil {
+		log.Warn().Err(err).Msg("invalid PUBLISH_TIMEOUT, using 5s")
+		publishTimeout = 5 * time.Second
+	}
+	if publishRetries, err = strconv.Atoi(env("PUBLISH_RETRIES", "2")); err != nil || publishRetries < 0 {
+		log.Warn().Msg("invalid PUBLISH_RETRIES, using 2")
+		publishRetries = 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := drainOutbox(100)
+			if err != nil {
+				log.Error().Err(err).Msg("outbox drain error")
+				break
+			}
+			if n == 0 {
+				break
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-outboxWake:
+		}
+	}
+}
+
+func drainOutbox(limit int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+SELECT o.id, o.job_id, o.payload, j.expires_at FROM outbox o LEFT JOIN jobs j ON j.id = o.job_id
+ORDER BY o.id LIMIT $1 FOR UPDATE OF o SKIP LOCKED`, limit)
+	if err != nil {
+		return 0, err
+	}
+	type entry struct {
+		id        int64
+		jobID     string
+		payload   []byte
+		expiresAt *time.Time
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.jobID, &e.payload, &e.expiresAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, e := range entries {
+		ttl, expired := expiration(e.expiresAt)
+		if expired {
+			// the message sat in the outbox past JOB_TTL (broker outage)
+			if err := expireJob(tx, e.jobID); err != nil {
+				return published, err
+			}
+			if _, err := tx.Exec(`DELETE FROM outbox WHERE id=$1`, e.id); err != nil {
+				return published, err
+			}
+			published++
+			continue
+		}
+		if err := publish(e.payload, ttl); err != nil {
+			publishFailingSince.CompareAndSwap(0, time.Now().UnixNano())
+			log.Error().Err(err).Int64("outbox_id", e.id).Msg("outbox publish error")
+			break
+		}
+		publishFailingSince.Store(0)
+		if _, err := tx.Exec(`DELETE FROM outbox WHERE id=$1`, e.id); err != nil {
+			return published, err
+		}
+		published++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if published < len(entries) {
+		// stop draining until the next tick; the broker is likely unavailable
+		return 0, nil
+	}
+	return published, nil
+}
+
+// publish sends one message and waits for the broker's confirm, retrying a
+// nacked, timed-out or failed attempt up to publishRetries times.
+func publish(body []byte, ttl string) error {
+	var err error
+	for attempt := 0; attempt <= publishRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 200 * time.Millisecond)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		err = publishConfirmed(ctx, body, ttl)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+var errNacked = errors.New("broker nacked the message")
+
+func publishConfirmed(ctx context.Context, body []byte, ttl string) error {
+	dc, err := amqpCh.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		Body:         body,
+		DeliveryMode: amqp.Persistent,
+		Expiration:   ttl,
+	})
+	if err != nil {
+		return err
+	}
+	acked, err := dc.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting for publish confirm: %w", err)
+	}
+	if !acked {
+		return errNacked
+	}
+	return nil
+}
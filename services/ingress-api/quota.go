@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var quotaRejections = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "ingress_upload_quota_rejections_total",
+	Help: "Uploads refused or cut off because the client used up UPLOAD_QUOTA_BYTES",
+})
+
+func init() {
+	prometheus.MustRegister(quotaRejections)
+}
+
+// uploadQuota caps the bytes each client may upload per window. It's nil,
+// and uploads unmetered, unless UPLOAD_QUOTA_BYTES is set. Counters live in
+// memory, so with several ingress replicas each enforces its own share.
+var uploadQuota *quotaTracker
+
+// quotaTracker counts bytes per client over fixed windows aligned to
+// UPLOAD_QUOTA_WINDOW (so a 24h window resets at midnight UTC). Bytes are
+// charged as they're read, which keeps concurrent uploads from the same
+// client from each seeing the full allowance.
+type quotaTracker struct {
+	limit  int64
+	window time.Duration
+	header string // request header naming the client; remote IP if empty
+
+	mu    sync.Mutex
+	start time.Time
+	used  map[string]int64
+}
+
+func loadUploadQuota() (*quotaTracker, error) {
+	v := os.Getenv("UPLOAD_QUOTA_BYTES")
+	if v == "" || v == "0" {
+		return nil, nil
+	}
+	limit, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("UPLOAD_QUOTA_BYTES must be a byte count")
+	}
+	window, err := time.ParseDuration(env("UPLOAD_QUOTA_WINDOW", "24h"))
+	if err != nil {
+		return nil, err
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("UPLOAD_QUOTA_WINDOW must be positive")
+	}
+	return &quotaTracker{limit: limit, window: window, header: os.Getenv("UPLOAD_QUOTA_CLIENT_HEADER"), used: map[string]int64{}}, nil
+}
+
+// client names who a request is charged to: the configured header (an API
+// key set by an auth proxy, say) when present, else the remote IP.
+func (q *quotaTracker) client(r *http.Request) string {
+	if q.header != "" {
+		if v := r.Header.Get(q.header); v != "" {
+			return v
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// roll starts a new window once the current one is over; q.mu must be held.
+func (q *quotaTracker) roll(now time.Time) {
+	if start := now.Truncate(q.window); start.After(q.start) {
+		q.start, q.used = start, map[string]int64{}
+	}
+}
+
+// charge adds n bytes to client's usage and returns the new total.
+func (q *quotaTracker) charge(client string, n int64) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(time.Now())
+	q.used[client] += n
+	return q.used[client]
+}
+
+// usage returns client's bytes so far and when the window resets.
+func (q *quotaTracker) usage(client string) (int64, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(time.Now())
+	return q.used[client], q.start.Add(q.window)
+}
+
+var (
+	// errQuotaExhausted means the client had no quota left when the upload began.
+	errQuotaExhausted = errors.New("upload quota exhausted for this window")
+	// errQuotaExceeded means the upload ran past the client's remaining quota.
+	errQuotaExceeded = errors.New("upload exceeds the remaining upload quota")
+)
+
+type quotaKey struct{}
+
+// withQuota meters a submit handler. A client already at its limit gets 429
+// without the body being read; otherwise submission.store, which finds the
+// client in the request context, stops the upload once it runs over. Every
+// response carries the client's usage as of when it was written.
+func withQuota(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if uploadQuota == nil {
+			next(w, r)
+			return
+		}
+		client := uploadQuota.client(r)
+		qw := &quotaWriter{ResponseWriter: w, client: client}
+		if used, reset := uploadQuota.usage(client); used >= uploadQuota.limit {
+			quotaRejections.Inc()
+			retry := int(time.Until(reset).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			http.Error(qw, errQuotaExhausted.Error(), http.StatusTooManyRequests)
+			return
+		}
+		next(qw, r.WithContext(context.WithValue(r.Context(), quotaKey{}, client)))
+	}
+}
+
+// quotaWriter sets the usage headers just before the status line goes out,
+// so they include the upload the response is about.
+type quotaWriter struct {
+	http.ResponseWriter
+	client  string
+	written bool
+}
+
+func (w *quotaWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		used, _ := uploadQuota.usage(w.client)
+		w.Header().Set("X-Upload-Quota-Limit", strconv.FormatInt(uploadQuota.limit, 10))
+		w.Header().Set("X-Upload-Quota-Used", strconv.FormatInt(used, 10))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// quotaReader charges bytes to the client as they're read and fails the
+// read that takes it over the limit.
+type quotaReader struct {
+	r        io.Reader
+	client   string
+	exceeded bool
+}
+
+// meterUpload wraps r when the request is metered.
+func meterUpload(ctx context.Context, r io.Reader) (io.Reader, *quotaReader) {
+	client, ok := ctx.Value(quotaKey{}).(string)
+	if !ok || uploadQuota == nil {
+		return r, nil
+	}
+	qr := &quotaReader{r: r, client: client}
+	return qr, qr
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	if n > 0 && uploadQuota.charge(q.client, int64(n)) > uploadQuota.limit {
+		q.exceeded = true
+		quotaRejections.Inc()
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var jobsRequeued = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "qc_jobs_requeued_total",
+	Help: "Total number of stuck processing jobs re-enqueued by the reconciler",
+})
+
+func init() {
+	prometheus.MustRegister(jobsRequeued)
+}
+
+// maxReprocess caps how many times a job may be re-enqueued (MAX_REPROCESS),
+// so a file that kills the worker every time can't loop forever.
+var maxReprocess int
+
+// runReconciler periodically looks for jobs stuck in 'processing' whose last
+// sign of life (heartbeat_at, falling back to started_at) is older than
+// STUCK_TIMEOUT, and puts them back on the queue. A worker that is still
+// chewing through a large file keeps heartbeat_at fresh and is left alone.
+func runReconciler() {
+	timeout, err := time.ParseDuration(env("STUCK_TIMEOUT", "30m"))
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid STUCK_TIMEOUT, using 30m")
+		timeout = 30 * time.Minute
+	}
+	interval, err := time.ParseDuration(env("STUCK_SWEEP_INTERVAL", "1m"))
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid STUCK_SWEEP_INTERVAL, using 1m")
+		interval = time.Minute
+	}
+	for {
+		if err := reconcileStuck(timeout); err != nil {
+			log.Error().Err(err).Msg("reconciler error")
+		}
+		if jobTTL > 0 {
+			if err := expireWaiting(interval); err != nil {
+				log.Error().Err(err).Msg("reconciler expiry error")
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func reconcileStuck(timeout time.Duration) error {
+	rows, err := db.Query(`
+SELECT id, queue_message FROM jobs
+WHERE status='processing'
+  AND COALESCE(heartbeat_at, started_at, submitted_at) < now() - $1::interval`, timeout.String())
+	if err != nil {
+		return err
+	}
+	type stuck struct {
+		id  string
+		msg []byte
+	}
+	var jobs []stuck
+	for rows.Next() {
+		var j stuck
+		if err := rows.Scan(&j.id, &j.msg); err != nil {
+			rows.Close()
+			return err
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, j := range jobs {
+		if err := requeueStuck(j.id, j.msg, timeout); err != nil {
+			log.Error().Err(err).Str("job_id", j.id).Msg("requeue error")
+		}
+	}
+	return nil
+}
+
+// requeueStuck moves a single job to 'retrying' and writes its original queue
+// message back to the outbox. The UPDATE re-checks the staleness condition, so concurrent
+// reconcilers or a late heartbeat make it a no-op. Jobs that have used up
+// MAX_REPROCESS requeues are failed instead.
+func requeueStuck(jobID string, body []byte, timeout time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const stale = `id=$1 AND status='processing' AND COALESCE(heartbeat_at, started_at, submitted_at) < now() - $2::interval`
+	if body == nil {
+		// jobs created before messages were recorded can't be re-enqueued
+		_, err := tx.Exec(`UPDATE jobs SET status='error', error='worker lost; job timed out', error_code='TIMEOUT' WHERE `+stale, jobID, timeout.String())
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	res, err := tx.Exec(`UPDATE jobs SET status='retrying', started_at=NULL, heartbeat_at=NULL, reprocess_count=reprocess_count+1
+WHERE `+stale+` AND reprocess_count < $3`, jobID, timeout.String(), maxReprocess)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		res, err := tx.Exec(`UPDATE jobs SET status='error', error=$3, error_code='TIMEOUT'
+WHERE `+stale+` AND reprocess_count >= $4`, jobID, timeout.String(),
+			fmt.Sprintf("worker lost; already requeued %d times (MAX_REPROCESS), giving up", maxReprocess), maxReprocess)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Warn().Str("job_id", jobID).Msg("stuck job hit MAX_REPROCESS; marked error")
+		}
+		return tx.Commit()
+	}
+	if err := enqueueOutbox(tx, jobID, body); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	jobsRequeued.Inc()
+	notifyOutbox()
+	log.Info().Str("job_id", jobID).Msg("requeued stuck job")
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var jobsRetried = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "qc_jobs_retried_total",
+	Help: "Total number of errored jobs re-enqueued by POST /admin/retry-errors",
+})
+
+func init() {
+	prometheus.MustRegister(jobsRetried)
+}
+
+// maxRetryBatch caps how many jobs one POST /admin/retry-errors requeues.
+const maxRetryBatch = 1000
+
+// retryMatch selects errored jobs by the request's filters: $1/$2 bound when
+// the failed attempt started (falling back to submission), $3 the
+// error_code. NULL leaves a filter out.
+const retryMatch = `status='error'
+  AND ($1::timestamptz IS NULL OR COALESCE(started_at, submitted_at) >= $1)
+  AND ($2::timestamptz IS NULL OR COALESCE(started_at, submitted_at) < $2)
+  AND ($3::text IS NULL OR error_code = $3)`
+
+// retryable narrows retryMatch to jobs that can run again: the message and
+// the file are still there, and the job hasn't used up MAX_REPROCESS ($4).
+const retryable = retryMatch + `
+  AND queue_message IS NOT NULL AND file_deleted_at IS NULL AND reprocess_count < $4`
+
+// handleRetryErrors serves POST /admin/retry-errors on ADMIN_ADDR: it puts up to limit
+// (default 100) errored jobs, oldest first, back to queued and their
+// original messages in the outbox, in one transaction. Optional since and
+// until (RFC 3339) and error_code narrow the selection. Each retry counts
+// against MAX_REPROCESS like a reconciler requeue; jobs past it, and jobs
+// whose file is gone, are reported but left alone. Call it again until
+// remaining is 0.
+func handleRetryErrors(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var since, until *time.Time
+	for name, dst := range map[string]**time.Time{"since": &since, "until": &until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, name+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*dst = &t
+		}
+	}
+	var code *string
+	if v := q.Get("error_code"); v != "" {
+		code = &v
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRetryBatch {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxRetryBatch), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var ttl *string // NULL clears expires_at, as setExpiry does without JOB_TTL
+	if jobTTL > 0 {
+		s := jobTTL.String()
+		ttl = &s
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`
+WITH picked AS (
+  SELECT id FROM jobs WHERE `+retryable+`
+  ORDER BY submitted_at LIMIT $5 FOR UPDATE SKIP LOCKED
+)
+UPDATE jobs j SET status='queued', error=NULL, error_code=NULL, started_at=NULL, heartbeat_at=NULL,
+  reprocess_count=reprocess_count+1, expires_at=now() + $6::interval
+FROM picked WHERE j.id = picked.id
+RETURNING j.id, j.queue_message`, since, until, code, maxReprocess, limit, ttl)
+	if err != nil {
+		log.Error().Err(err).Msg("retry-errors: update error")
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	var ids []string
+	var payloads [][]byte
+	for rows.Next() {
+		var id string
+		var body []byte
+		if err := rows.Scan(&id, &body); err != nil {
+			rows.Close()
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		ids, payloads = append(ids, id), append(payloads, body)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if len(ids) > 0 {
+		_, err = tx.Exec(`INSERT INTO outbox (job_id, payload) SELECT * FROM unnest($1::uuid[], $2::bytea[])`, ids, payloads)
+		if err != nil {
+			log.Error().Err(err).Msg("retry-errors: outbox error")
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		// results-api may still hold the error response; the notifications
+		// go out on commit
+		_, err = tx.Exec(`SELECT pg_notify('qc_job_changed', id::text) FROM unnest($1::uuid[]) id`, ids)
+		if err != nil {
+			log.Error().Err(err).Msg("retry-errors: notify error")
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
+	var remaining, overLimit, noInput int
+	err = tx.QueryRow(`
+SELECT count(*) FILTER (WHERE queue_message IS NOT NULL AND file_deleted_at IS NULL AND reprocess_count < $4),
+       count(*) FILTER (WHERE reprocess_count >= $4),
+       count(*) FILTER (WHERE (queue_message IS NULL OR file_deleted_at IS NOT NULL) AND reprocess_count < $4)
+FROM jobs WHERE `+retryMatch, since, until, code, maxReprocess).Scan(&remaining, &overLimit, &noInput)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	jobsRetried.Add(float64(len(ids)))
+	if len(ids) > 0 {
+		notifyOutbox()
+	}
+	log.Info().Int("requeued", len(ids)).Int("remaining", remaining).Int("over_reprocess_limit", overLimit).
+		Int("no_input", noInput).Msg("retried errored jobs")
+
+	if ids == nil {
+		ids = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Requeued           []string `json:"requeued"`
+		Remaining          int      `json:"remaining"`
+		OverReprocessLimit int      `json:"over_reprocess_limit"`
+		NoInput            int      `json:"no_input"`
+	}{ids, remaining, overLimit, noInput})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpSource is how ingress logs in to the SFTP servers sequencers drop
+// files on. It's nil, and POST /submit/sftp refused, unless
+// SFTP_ALLOWED_HOSTS is set.
+type sftpSource struct {
+	allowed map[string]bool // host:port
+	baseDir string          // remote paths must be under this, if set
+	config  *ssh.ClientConfig
+}
+
+var sftpSrc *sftpSource
+
+// loadSFTPSource reads the SFTP_* settings. Credentials come from the
+// environment or from files (a mounted secret): SFTP_USER with
+// SFTP_PASSWORD and/or SFTP_KEY_FILE. Host keys are always checked against
+// SFTP_KNOWN_HOSTS.
+func loadSFTPSource() (*sftpSource, error) {
+	hosts := os.Getenv("SFTP_ALLOWED_HOSTS")
+	if hosts == "" {
+		return nil, nil
+	}
+	src := &sftpSource{allowed: map[string]bool{}, baseDir: os.Getenv("SFTP_BASE_DIR")}
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			src.allowed[withSFTPPort(strings.ToLower(h))] = true
+		}
+	}
+	if src.baseDir != "" {
+		if !strings.HasPrefix(src.baseDir, "/") {
+			return nil, fmt.Errorf("SFTP_BASE_DIR must be an absolute path")
+		}
+		src.baseDir = path.Clean(src.baseDir)
+	}
+
+	knownHostsFile := os.Getenv("SFTP_KNOWN_HOSTS")
+	if knownHostsFile == "" {
+		return nil, fmt.Errorf("SFTP_KNOWN_HOSTS is required with SFTP_ALLOWED_HOSTS")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("SFTP_KNOWN_HOSTS: %w", err)
+	}
+	var auth []ssh.AuthMethod
+	if keyFile := os.Getenv("SFTP_KEY_FILE"); keyFile != "" {
+		pem, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("SFTP_KEY_FILE: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("SFTP_KEY_FILE: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if pw := os.Getenv("SFTP_PASSWORD"); pw != "" {
+		auth = append(auth, ssh.Password(pw))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("SFTP_ALLOWED_HOSTS needs SFTP_KEY_FILE or SFTP_PASSWORD")
+	}
+	timeout, err := time.ParseDuration(env("SFTP_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, err
+	}
+	src.config = &ssh.ClientConfig{
+		User:            os.Getenv("SFTP_USER"),
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         timeout,
+	}
+	return src, nil
+}
+
+func withSFTPPort(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, "22")
+}
+
+var errSFTPHost = errors.New("host is not in SFTP_ALLOWED_HOSTS")
+
+// resolve checks an sftp:// URL against the host allowlist and returns the
+// address to dial and the cleaned remote path. Any ".." segment is
+// rejected outright rather than cleaned away, and the result must stay
+// under SFTP_BASE_DIR.
+func (s *sftpSource) resolve(raw string) (addr, remote string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "sftp" || u.Host == "" {
+		return "", "", fmt.Errorf("sftp_url must look like sftp://host/path/to/file.fastq")
+	}
+	if u.User != nil {
+		return "", "", fmt.Errorf("sftp_url must not carry credentials")
+	}
+	addr = withSFTPPort(strings.ToLower(u.Host))
+	if !s.allowed[addr] {
+		return "", "", errSFTPHost
+	}
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg == ".." {
+			return "", "", fmt.Errorf("sftp_url path must not contain ..")
+		}
+	}
+	remote = path.Clean(u.Path)
+	if !strings.HasPrefix(remote, "/") || remote == "/" || strings.HasSuffix(u.Path, "/") {
+		return "", "", fmt.Errorf("sftp_url must name a file")
+	}
+	if s.baseDir != "" && s.baseDir != "/" && !strings.HasPrefix(remote, s.baseDir+"/") {
+		return "", "", fmt.Errorf("sftp_url path must be under %s", s.baseDir)
+	}
+	return addr, remote, nil
+}
+
+// dial opens an SFTP session; the returned func closes it along with the
+// SSH connection underneath. ClientConfig.Timeout only applies inside
+// ssh.Dial, so the handshake and SFTP setup get it as a deadline on the
+// connection, cleared before the transfer.
+func (s *sftpSource) dial(ctx context.Context, addr string) (*sftp.Client, func(), error) {
+	d := net.Dialer{Timeout: s.config.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.config.Timeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, s.config)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	sshClient := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return client, func() { client.Close(); sshClient.Close() }, nil
+}
+
+// handleSubmitSFTP creates a job from a file on an allowlisted SFTP server,
+// streaming it into storage like an upload. The JSON body holds sftp_url
+// plus the usual submit options (interleaved, count_only, metadata, ...).
+func handleSubmitSFTP(w http.ResponseWriter, r *http.Request) {
+	if sftpSrc == nil {
+		http.Error(w, "SFTP ingestion is not enabled (SFTP_ALLOWED_HOSTS)", http.StatusForbidden)
+		return
+	}
+	if queueUnavailable() {
+		writeQueueUnavailable(w)
+		return
+	}
+	fields, ok := readJSONFields(w, r)
+	if !ok {
+		return
+	}
+	addr, remote, err := sftpSrc.resolve(fields["sftp_url"])
+	if err == errSFTPHost {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub := newSubmission()
+	defer sub.cleanup()
+	if err := sub.parseOptions(func(k string) string { return fields[k] }); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := sub.parseMetadata(fields); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, closeClient, err := sftpSrc.dial(r.Context(), addr)
+	if err != nil {
+		log.Error().Err(err).Str("host", addr).Msg("sftp connect error")
+		http.Error(w, "could not connect to the SFTP server", http.StatusBadGateway)
+		return
+	}
+	defer closeClient()
+	f, err := client.Open(remote)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "remote file not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("host", addr).Str("path", remote).Msg("sftp open error")
+		http.Error(w, "could not open the remote file", http.StatusBadGateway)
+		return
+	}
+	defer f.Close()
+	if err := sub.store(r.Context(), path.Base(remote), f); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := sub.enqueue(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	log.Info().Str("job_id", sub.jobID).Str("host", addr).Str("path", remote).Msg("job submitted from sftp")
+	writeSubmitted(w, sub)
+}
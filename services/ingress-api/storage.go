@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Storage is where uploaded FASTQ files live. Keys are what ingress records
+// in jobs.path and sends in QueueMessage.Path; the worker opens the same key
+// through its own Storage.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Rename moves an object to a new key within the same backend.
+	Rename(ctx context.Context, from, to string) error
+	// Stat returns the object's size; a missing object gives an error
+	// wrapping fs.ErrNotExist.
+	Stat(ctx context.Context, key string) (int64, error)
+}
+
+// newStorage picks the backend from STORAGE_BACKEND (local or s3).
+func newStorage(ctx context.Context) (Storage, error) {
+	return newTierStorage(ctx, "")
+}
+
+// newColdStorage is the archive tier, configured like the main one with
+// every variable prefixed COLD_ (COLD_STORAGE_BACKEND, COLD_S3_BUCKET, ...).
+// It returns nil when COLD_STORAGE_BACKEND is unset.
+func newColdStorage(ctx context.Context) (Storage, error) {
+	if os.Getenv("COLD_STORAGE_BACKEND") == "" {
+		return nil, nil
+	}
+	return newTierStorage(ctx, "COLD_")
+}
+
+func newTierStorage(ctx context.Context, prefix string) (Storage, error) {
+	switch backend := env(prefix+"STORAGE_BACKEND", "local"); backend {
+	case "local":
+		if prefix == "" {
+			return &localStorage{root: env("UPLOAD_DIR", "/data/uploads")}, nil
+		}
+		return newColdLocalStorage(prefix)
+	case "s3":
+		return newS3Storage(ctx, prefix)
+	default:
+		return nil, fmt.Errorf("unknown %sSTORAGE_BACKEND %q", prefix, backend)
+	}
+}
+
+// newColdLocalStorage is a local cold tier. Its directory has no default and
+// must not be the hot tier's: archiving the same directory onto itself
+// would truncate each file while copying it and then delete the only copy.
+func newColdLocalStorage(prefix string) (Storage, error) {
+	root := os.Getenv(prefix + "UPLOAD_DIR")
+	if root == "" {
+		return nil, fmt.Errorf("%sUPLOAD_DIR is required when %sSTORAGE_BACKEND is local", prefix, prefix)
+	}
+	if env("STORAGE_BACKEND", "local") == "local" {
+		same, err := sameDir(root, env("UPLOAD_DIR", "/data/uploads"))
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			return nil, fmt.Errorf("%sUPLOAD_DIR must differ from UPLOAD_DIR", prefix)
+		}
+	}
+	return &localStorage{root: root}, nil
+}
+
+// sameDir reports whether a and b name the same directory, following
+// symlinks where the paths exist.
+func sameDir(a, b string) (bool, error) {
+	resolve := func(p string) (string, error) {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return "", err
+		}
+		if r, err := filepath.EvalSymlinks(p); err == nil {
+			p = r
+		}
+		return p, nil
+	}
+	ra, err := resolve(a)
+	if err != nil {
+		return false, err
+	}
+	rb, err := resolve(b)
+	if err != nil {
+		return false, err
+	}
+	return ra == rb, nil
+}
+
+// localStorage keeps files under root. Keys are relative to root; absolute
+// paths inside root (recorded by older versions) are accepted too.
+type localStorage struct {
+	root string
+}
+
+func (l *localStorage) path(key string) (string, error) {
+	p := key
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(l.root, key)
+	}
+	p = filepath.Clean(p)
+	rel, err := filepath.Rel(filepath.Clean(l.root), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("storage key %q escapes %s", key, l.root)
+	}
+	return p, nil
+}
+
+// Put writes the file and fsyncs it and its directory, so a job is never
+// enqueued for bytes that a crash could lose.
+func (l *localStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := out.ReadFrom(r); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(p))
+}
+
+func (l *localStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (l *localStorage) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *localStorage) Rename(ctx context.Context, from, to string) error {
+	src, err := l.path(from)
+	if err != nil {
+		return err
+	}
+	dst, err := l.path(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dst))
+}
+
+func (l *localStorage) Stat(ctx context.Context, key string) (int64, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	if !fi.Mode().IsRegular() {
+		return 0, fmt.Errorf("storage key %q is not a file", key)
+	}
+	return fi.Size(), nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// s3Storage stores objects under S3_PREFIX in S3_BUCKET. Credentials and
+// region come from the standard AWS environment/config chain; S3_ENDPOINT
+// points it at an S3-compatible store such as MinIO. S3_STORAGE_CLASS (e.g.
+// GLACIER_IR for the cold tier) applies to new objects.
+type s3Storage struct {
+	client       *s3.Client
+	bucket       string
+	prefix       string
+	storageClass types.StorageClass
+}
+
+func newS3Storage(ctx context.Context, envPrefix string) (*s3Storage, error) {
+	bucket := os.Getenv(envPrefix + "S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("%sS3_BUCKET is required for the s3 storage backend", envPrefix)
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if ep := os.Getenv(envPrefix + "S3_ENDPOINT"); ep != "" {
+			o.BaseEndpoint = aws.String(ep)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Storage{
+		client:       client,
+		bucket:       bucket,
+		prefix:       os.Getenv(envPrefix + "S3_PREFIX"),
+		storageClass: types.StorageClass(os.Getenv(envPrefix + "S3_STORAGE_CLASS")),
+	}, nil
+}
+
+func (s *s3Storage) key(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+// Put streams r with the multipart uploader, which handles bodies of unknown
+// length (like a request stream) without buffering them to disk.
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := manager.NewUploader(s.client).Upload(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(s.key(key)),
+		Body:         r,
+		StorageClass: s.storageClass,
+	})
+	return err
+}
+
+func (s *s3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	return err
+}
+
+func (s *s3Storage) Stat(ctx context.Context, key string) (int64, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return 0, fmt.Errorf("s3://%s/%s: %w", s.bucket, s.key(key), fs.ErrNotExist)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return aws.ToInt64(out.ContentLength), nil
+}
+
+// Rename copies the object server-side and deletes the original. S3 has no
+// rename, and a single CopyObject is limited to 5 GB.
+func (s *s3Storage) Rename(ctx context.Context, from, to string) error {
+	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(s.key(to)),
+		CopySource:   aws.String((&url.URL{Path: s.bucket + "/" + s.key(from)}).EscapedPath()),
+		StorageClass: s.storageClass,
+	})
+	if err != nil {
+		return err
+	}
+	return s.Delete(ctx, from)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// listenAndServe serves plain HTTP unless TLS_CERT_FILE and TLS_KEY_FILE are
+// set. With TLS_CLIENT_CA as well, clients must present a certificate signed
+// by that CA (mTLS).
+func listenAndServe(addr string, h http.Handler) error {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	clientCA := os.Getenv("TLS_CLIENT_CA")
+	if certFile == "" && keyFile == "" {
+		if clientCA != "" {
+			return fmt.Errorf("TLS_CLIENT_CA requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return http.ListenAndServe(addr, h)
+	}
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	srv := &http.Server{Addr: addr, Handler: h, TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}}
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			return fmt.Errorf("TLS_CLIENT_CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("TLS_CLIENT_CA: no certificates found in %s", clientCA)
+		}
+		srv.TLSConfig.ClientCAs = pool
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return srv.ListenAndServeTLS(certFile, keyFile)
+}
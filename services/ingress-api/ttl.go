@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var jobsExpired = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "qc_jobs_expired_total",
+	Help: "Total number of waiting jobs failed because their JOB_TTL ran out",
+})
+
+func init() {
+	prometheus.MustRegister(jobsExpired)
+}
+
+// jobTTL (JOB_TTL) bounds how long a job may wait for a worker. Every queue
+// message is published with the time left as its AMQP expiration, so the
+// broker drops (or, with a dead-letter policy on the queue, dead-letters)
+// messages that sat out a long worker outage; 0 disables it.
+var jobTTL time.Duration
+
+// setExpiry records when the job's pending message stops being worth
+// processing. It's reset on every (re)enqueue, so a requeued job gets a
+// fresh TTL.
+func setExpiry(tx *sql.Tx, jobID string) error {
+	if jobTTL <= 0 {
+		_, err := tx.Exec(`UPDATE jobs SET expires_at=NULL WHERE id=$1`, jobID)
+		return err
+	}
+	_, err := tx.Exec(`UPDATE jobs SET expires_at=now() + $2::interval WHERE id=$1`, jobID, jobTTL.String())
+	return err
+}
+
+// expiration renders the time left until expiresAt as an AMQP per-message
+// TTL in milliseconds; expired reports whether none is left.
+func expiration(expiresAt *time.Time) (ttl string, expired bool) {
+	if expiresAt == nil {
+		return "", false
+	}
+	left := time.Until(*expiresAt).Milliseconds()
+	if left <= 0 {
+		return "", true
+	}
+	return strconv.FormatInt(left, 10), false
+}
+
+const expiredReason = "job waited longer than JOB_TTL for a worker; its queue message was dropped"
+
+// expireJob fails a job whose message expired before it could be published.
+func expireJob(tx *sql.Tx, jobID string) error {
+	res, err := tx.Exec(`UPDATE jobs SET status='error', error=$2, error_code='EXPIRED'
+WHERE id=$1 AND status IN ('queued','retrying')`, jobID, expiredReason)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		jobsExpired.Inc()
+	}
+	return nil
+}
+
+// expireWaiting fails jobs still waiting past expires_at: the broker has
+// dropped their message by now, so nothing else would ever move them on.
+// The grace covers a message delivered just before it expired whose worker
+// hasn't marked the job processing yet.
+func expireWaiting(grace time.Duration) error {
+	res, err := db.Exec(`UPDATE jobs SET status='error', error=$1, error_code='EXPIRED'
+WHERE status IN ('queued','retrying') AND expires_at < now() - $2::interval`, expiredReason, grace.String())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		jobsExpired.Add(float64(n))
+		log.Warn().Int64("jobs", n).Msg("failed jobs whose JOB_TTL ran out")
+	}
+	return nil
+}
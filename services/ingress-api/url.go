@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// urlSource is the allowlist for POST /submit/url. It's nil, and the
+// endpoint refused, unless URL_ALLOWED_HOSTS is set.
+type urlSource struct {
+	allowed map[string]bool // lowercase host or host:port
+	client  *http.Client
+}
+
+var urlSrc *urlSource
+
+func loadURLSource() (*urlSource, error) {
+	hosts := os.Getenv("URL_ALLOWED_HOSTS")
+	if hosts == "" {
+		return nil, nil
+	}
+	timeout, err := time.ParseDuration(env("URL_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, err
+	}
+	src := &urlSource{allowed: map[string]bool{}, client: &http.Client{Timeout: timeout}}
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			src.allowed[strings.ToLower(h)] = true
+		}
+	}
+	// a redirect mustn't lead the probe off the allowlist
+	src.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !src.allows(req.URL) {
+			return errURLHost
+		}
+		return nil
+	}
+	return src, nil
+}
+
+func (s *urlSource) allows(u *url.URL) bool {
+	return s.allowed[strings.ToLower(u.Host)] || s.allowed[strings.ToLower(u.Hostname())]
+}
+
+var errURLHost = errors.New("host is not in URL_ALLOWED_HOSTS")
+
+// resolve checks a submitted URL: http(s), no credentials, and a host on
+// the allowlist (by name, or name:port when a port is given).
+func (s *urlSource) resolve(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must look like https://host/path/to/file.fastq.gz")
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("url must not carry credentials")
+	}
+	if !s.allows(u) {
+		return nil, errURLHost
+	}
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("url must name a file")
+	}
+	return u, nil
+}
+
+// probe checks the file is there with a HEAD request and returns its size
+// (-1 if the server doesn't say).
+func (s *urlSource) probe(ctx context.Context, u *url.URL) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("remote answered %s", resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
+// handleSubmitURL creates a job for a file the worker streams straight from
+// an allowlisted HTTP(S) server: nothing is downloaded into storage, so
+// there's no SHA-256, download or head for such jobs, and a reprocess reads
+// the remote again. The JSON body holds url plus the usual submit options.
+func handleSubmitURL(w http.ResponseWriter, r *http.Request) {
+	if urlSrc == nil {
+		http.Error(w, "URL ingestion is not enabled (URL_ALLOWED_HOSTS)", http.StatusForbidden)
+		return
+	}
+	if queueUnavailable() {
+		writeQueueUnavailable(w)
+		return
+	}
+	fields, ok := readJSONFields(w, r)
+	if !ok {
+		return
+	}
+	u, err := urlSrc.resolve(fields["url"])
+	if err == errURLHost {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filename := path.Base(u.Path)
+	if err := checkExtension(filename); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	sub := newSubmission()
+	if err := sub.parseOptions(func(k string) string { return fields[k] }); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := sub.parseMetadata(fields); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	size, err := urlSrc.probe(r.Context(), u)
+	if err != nil {
+		log.Warn().Err(err).Str("url", u.Redacted()).Msg("url probe error")
+		http.Error(w, "could not reach the remote file: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	sub.filename, sub.sourceURL = filename, u.String()
+	if size > 0 {
+		sub.size = size
+	}
+	if err := sub.enqueue(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	log.Info().Str("job_id", sub.jobID).Str("url", u.Redacted()).Msg("job submitted from url")
+	writeSubmitted(w, sub)
+}
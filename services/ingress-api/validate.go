@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// validateRecords is how many records /validate inspects by default
+// (VALIDATE_RECORDS).
+var validateRecords int
+
+// validation is the /validate response. Exhaustive is false when the check
+// stopped at the record limit, so "valid" only means "probably valid".
+type validation struct {
+	Valid          bool   `json:"valid"`
+	Format         string `json:"format,omitempty"`
+	RecordsChecked int    `json:"records_checked"`
+	Exhaustive     bool   `json:"exhaustive"`
+	Error          string `json:"error,omitempty"`
+}
+
+// handleValidate structurally checks the leading records of the raw request
+// body (plain or gzipped) without storing it or creating a job. A records
+// query parameter overrides VALIDATE_RECORDS.
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	limit := validateRecords
+	if v := r.URL.Query().Get("records"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "records must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	br := bufio.NewReader(r.Body)
+	var in io.Reader = br
+	if head, _ := br.Peek(2); len(head) == 2 && head[0] == 0x1f && head[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			writeValidation(w, validation{Error: "corrupt gzip header"})
+			return
+		}
+		in = zr
+	}
+	writeValidation(w, validateStream(in, limit))
+}
+
+func writeValidation(w http.ResponseWriter, v validation) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// validateStream checks up to limit FASTQ or FASTA records: headers, the
+// '+' separator, and sequence/quality lengths for FASTQ.
+func validateStream(r io.Reader, limit int) validation {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	next := func() (string, bool) {
+		if !sc.Scan() {
+			return "", false
+		}
+		return strings.TrimRight(sc.Text(), " \t\r"), true
+	}
+
+	var v validation
+	line, ok := next()
+	for ok && line == "" {
+		line, ok = next()
+	}
+	switch {
+	case !ok:
+		v.Error = "empty input"
+	case line[0] == '@':
+		v.Format = "fastq"
+		v.validateFASTQ(line, next, limit)
+	case line[0] == '>':
+		v.Format = "fasta"
+		v.validateFASTA(next, limit)
+	default:
+		v.Error = fmt.Sprintf("input is not FASTQ or FASTA (first byte %q)", line[0])
+	}
+	if err := sc.Err(); err != nil && v.Error == "" {
+		v.Error = err.Error()
+	}
+	v.Valid = v.Error == ""
+	return v
+}
+
+func (v *validation) validateFASTQ(header string, next func() (string, bool), limit int) {
+	for {
+		if v.RecordsChecked == limit {
+			return
+		}
+		rec := v.RecordsChecked + 1
+		if header == "" || header[0] != '@' {
+			v.Error = fmt.Sprintf("record %d: header does not start with '@'", rec)
+			return
+		}
+		seq, ok1 := next()
+		plus, ok2 := next()
+		qual, ok3 := next()
+		switch {
+		case !ok1 || !ok2 || !ok3:
+			v.Error = fmt.Sprintf("record %d: truncated", rec)
+			return
+		case plus == "" || plus[0] != '+':
+			v.Error = fmt.Sprintf("record %d: missing '+' separator", rec)
+			return
+		case len(seq) != len(qual):
+			v.Error = fmt.Sprintf("record %d: sequence length %d != quality length %d", rec, len(seq), len(qual))
+			return
+		}
+		v.RecordsChecked++
+		var ok bool
+		header, ok = next()
+		for ok && header == "" {
+			header, ok = next() // blank lines are tolerated between records
+		}
+		if !ok {
+			v.Exhaustive = true
+			return
+		}
+	}
+}
+
+func (v *validation) validateFASTA(next func() (string, bool), limit int) {
+	bases := 0
+	for {
+		line, ok := next()
+		if !ok || (line != "" && line[0] == '>') {
+			if bases == 0 {
+				v.Error = fmt.Sprintf("record %d: no sequence", v.RecordsChecked+1)
+				return
+			}
+			v.RecordsChecked++
+			if !ok {
+				v.Exhaustive = true
+				return
+			}
+			if v.RecordsChecked == limit {
+				return
+			}
+			bases = 0
+			continue
+		}
+		bases += len(line)
+	}
+}
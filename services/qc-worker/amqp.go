@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// dialAMQP connects with a configurable heartbeat (AMQP_HEARTBEAT). TLS is
+// used for amqps:// URLs, trusting the CA bundle in AMQP_CA_FILE if set and
+// the system roots otherwise.
+func dialAMQP(url string) (*amqp.Connection, error) {
+	heartbeat, err := time.ParseDuration(env("AMQP_HEARTBEAT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("AMQP_HEARTBEAT: %w", err)
+	}
+	cfg := amqp.Config{Heartbeat: heartbeat, Locale: "en_US"}
+
+	if caFile := os.Getenv("AMQP_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("AMQP_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("AMQP_CA_FILE: no certificates found in %s", caFile)
+		}
+		// only consulted for amqps:// URLs
+		cfg.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return amqp.DialConfig(url, cfg)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// record is one parsed read as handed to analyzers. Qual is empty for FASTA.
+type record struct {
+	Header string
+	Seq    string
+	Qual   string
+}
+
+// Analyzer is an optional metric computed alongside the always-on core
+// counters (reads, lengths, GC/N, per-base composition). Each one sees every
+// record and reports a JSON-able summary at the end, stored under its name
+// in qc_results.analyses.
+type Analyzer interface {
+	Consume(rec *record)
+	Finalize() map[string]any
+}
+
+// analyzerRegistry maps ANALYZERS names to constructors. Ingress checks the
+// per-job analyzers option against its own copy of the names
+// (analyzerNames in ingress-api/main.go), and the README lists them under
+// ANALYZERS; keep all three in step when adding one.
+var analyzerRegistry = map[string]func(opts qcOptions) Analyzer{
+	"quality":         newQualityAnalyzer,
+	"overrepresented": newOverrepAnalyzer,
+	"complexity":      newComplexityAnalyzer,
+	"gc_distribution": newGCDistAnalyzer,
+}
+
+// parseAnalyzers reads a comma-separated list of analyzer names; "none"
+// selects none.
+func parseAnalyzers(v string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "none" {
+			continue
+		}
+		if _, ok := analyzerRegistry[name]; !ok {
+			known := make([]string, 0, len(analyzerRegistry))
+			for k := range analyzerRegistry {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown analyzer %q (known: %s)", name, strings.Join(known, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// analyzersFor returns the analyzers msg's job runs: the ones named at
+// submit if any, otherwise ANALYZERS. Ingress checks the names against its
+// own list, so one this worker doesn't know means the two are out of step;
+// the job runs without it rather than failing.
+func analyzersFor(ctx context.Context, msg QueueMessage) []string {
+	if msg.Analyzers == "" {
+		return enabledAnalyzers
+	}
+	var names []string
+	for _, name := range strings.Split(msg.Analyzers, ",") {
+		if name == "none" {
+			continue
+		}
+		if _, ok := analyzerRegistry[name]; !ok {
+			zerolog.Ctx(ctx).Warn().Str("analyzer", name).Msg("unknown analyzer requested; skipped")
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// pipeline runs the selected analyzers over every record.
+type pipeline struct {
+	names     []string
+	analyzers []Analyzer
+}
+
+func newPipeline(opts qcOptions) *pipeline {
+	p := &pipeline{}
+	for _, name := range opts.Analyzers {
+		p.names = append(p.names, name)
+		p.analyzers = append(p.analyzers, analyzerRegistry[name](opts))
+	}
+	return p
+}
+
+func (p *pipeline) empty() bool {
+	return len(p.analyzers) == 0
+}
+
+func (p *pipeline) consume(rec *record) {
+	for _, a := range p.analyzers {
+		a.Consume(rec)
+	}
+}
+
+// finalize collects each analyzer's summary by name.
+func (p *pipeline) finalize() map[string]map[string]any {
+	if p.empty() {
+		return nil
+	}
+	out := make(map[string]map[string]any, len(p.analyzers))
+	for i, a := range p.analyzers {
+		out[p.names[i]] = a.Finalize()
+	}
+	return out
+}
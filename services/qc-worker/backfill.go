@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxBackfillBatch caps how many jobs one POST /admin/backfill re-reads.
+const maxBackfillBatch = 100
+
+// backfillCandidates are finished jobs whose stored file can still be read
+// in full and whose analyses lack the metric. Downsampled jobs are left
+// out, since their analyses describe the sample rather than the file.
+const backfillCandidates = `
+FROM jobs j JOIN qc_results q ON q.job_id = j.id
+WHERE j.status = 'done' AND j.file_deleted_at IS NULL AND j.storage_tier = 'hot'
+  AND NOT q.partial AND NOT q.count_only AND q.input_reads IS NULL
+  AND NOT (COALESCE(q.analyses, '{}'::jsonb) ? $1)`
+
+// handleBackfill serves POST /admin/backfill?metric=<analyzer>&limit=N: it
+// re-reads up to limit finished jobs that predate the analyzer, runs just
+// that analyzer over each and adds its summary to qc_results.analyses,
+// leaving every other result alone. Call it repeatedly until remaining is
+// 0. Analyzers with per-position detail (quality) aren't supported; those
+// jobs need a reprocess.
+func handleBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	metric := r.URL.Query().Get("metric")
+	newAnalyzer, ok := analyzerRegistry[metric]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown metric %q", metric), http.StatusBadRequest)
+		return
+	}
+	switch newAnalyzer(qcOptions{}).(type) {
+	case tableSaver, blobSaver:
+		http.Error(w, metric+" stores per-position detail and can't be backfilled; reprocess the jobs instead", http.StatusBadRequest)
+		return
+	}
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxBackfillBatch {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxBackfillBatch), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.Query(`SELECT j.id, j.queue_message`+backfillCandidates+` ORDER BY j.submitted_at LIMIT $2`, metric, limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	var msgs []QueueMessage
+	for rows.Next() {
+		var id string
+		var body []byte
+		if err := rows.Scan(&id, &body); err != nil {
+			rows.Close()
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		var msg QueueMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			log.Warn().Err(err).Str("job_id", id).Msg("backfill: unreadable queue message")
+		}
+		msg.JobID = id
+		msgs = append(msgs, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	updated := []string{}
+	failed := map[string]string{}
+	for _, msg := range msgs {
+		if err := backfillJob(r.Context(), msg, metric); err != nil {
+			log.Warn().Err(err).Str("job_id", msg.JobID).Str("metric", metric).Msg("backfill failed")
+			failed[msg.JobID] = err.Error()
+			continue
+		}
+		updated = append(updated, msg.JobID)
+	}
+	var remaining int
+	if err := db.QueryRow(`SELECT count(*)`+backfillCandidates, metric).Scan(&remaining); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	log.Info().Str("metric", metric).Int("updated", len(updated)).Int("failed", len(failed)).
+		Int("remaining", remaining).Msg("backfill batch done")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Metric    string            `json:"metric"`
+		Updated   []string          `json:"updated"`
+		Failed    map[string]string `json:"failed"`
+		Remaining int               `json:"remaining"`
+	}{metric, updated, failed, remaining})
+}
+
+// backfillJob re-reads one job's file through the named analyzer alone and
+// merges its summary into the stored analyses. The core counters, checks
+// and other analyzers don't run. A job that fails here keeps its results
+// and is picked up again by the next batch.
+func backfillJob(ctx context.Context, msg QueueMessage, metric string) error {
+	f, err := openInput(ctx, msg)
+	if err != nil {
+		return storageErr(err)
+	}
+	defer f.Close()
+	text, _, err := decompress(f)
+	if err != nil {
+		return err
+	}
+	summary, err := runAnalyzer(ctx, text, metric, qcOptions{
+		MaxReadLength:         maxReadLength,
+		MaxPositions:          maxPositions,
+		MinReadLength:         minReadLength,
+		OverrepMaxDistinct:    overrepMaxDistinct,
+		ComplexitySampleEvery: complexitySampleEvery,
+		ComplexityMinEntropy:  complexityMinEntropy,
+		Window:                posWindow{Start: msg.PositionStart, End: msg.PositionEnd},
+	})
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	// the summary goes into qc_json's analyses too, if the job has one
+	_, err = db.Exec(`UPDATE qc_results SET analyses = COALESCE(analyses, '{}'::jsonb) || jsonb_build_object($2::text, $3::jsonb),
+  qc_json = jsonb_set(qc_json, '{analyses}', COALESCE(qc_json->'analyses', '{}'::jsonb) || jsonb_build_object($2::text, $3::jsonb))
+WHERE job_id=$1`, msg.JobID, metric, b)
+	if err != nil {
+		return err
+	}
+	if err := notifyJobChanged(msg.JobID); err != nil {
+		// the stored summary is in; results-api's cache just serves the old
+		// one until RESULT_CACHE_TTL
+		log.Warn().Err(err).Str("job_id", msg.JobID).Msg("notify job change")
+	}
+	return nil
+}
+
+// runAnalyzer feeds every record of r to a single analyzer and returns its
+// summary. Reads shorter than opts.MinReadLength are skipped, as in a job.
+func runAnalyzer(ctx context.Context, r io.Reader, name string, opts qcOptions) (map[string]any, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	first, err := sniffFormat(br)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(br)
+	sc.Buffer(make([]byte, 0, 64*1024), opts.MaxReadLength+2)
+	it := newRecordIterator(first, sc, opts.MaxReadLength)
+	a := analyzerRegistry[name](opts)
+	for n := 0; ; n++ {
+		rec, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec.Seq) >= opts.MinReadLength {
+			a.Consume(rec)
+		}
+		if n%progressEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return a.Finalize(), nil
+}
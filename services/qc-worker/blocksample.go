@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// remoteBlockSampling lets downsampled URL jobs on BGZF files fetch only
+// the chunks their sample needs (REMOTE_BLOCK_SAMPLING); see openBlockSample.
+var remoteBlockSampling = true
+
+// blockSampleChunk is how much of the file one Range request covers. A
+// chunk's reads are kept together, so smaller chunks spread the sample over
+// more of the file but lose more reads cut at chunk edges.
+var blockSampleChunk int64 = 1 << 20
+
+// bgzfMaxBlock is the largest a BGZF block can be (BSIZE is 16 bits). Each
+// request reads this far past its chunk to finish the last block starting
+// in it.
+const bgzfMaxBlock = 64 << 10
+
+// blockSampler feeds a downsampled job the reads of randomly chosen chunks
+// of a remote BGZF file instead of the whole file. Each chunk is one Range
+// request; the BGZF blocks starting in it are inflated and trimmed to whole
+// records (whole pairs, when the headers carry mate markers). Chunks are
+// drawn in an order fixed by the job's seed until the reservoir has enough
+// reads to choose from, and every request carries If-Range with the first
+// response's validator, so a file that changes mid-job fails it.
+type blockSampler struct {
+	ctx     context.Context
+	url     string
+	size    int64
+	ifRange string // ETag or Last-Modified of the first response
+	target  int
+	minLen  int
+	order   []int // chunk indexes in the seeded draw order
+	next    int
+	first   *sampledChunk // chunk 0, read to decide whether to sample at all
+	buf     []byte
+
+	usable   int   // reads handed over that the length filter keeps
+	kept     int64 // records handed over
+	starts   int64 // records starting in the chunks read, kept or not
+	inflated int64 // compressed size of the blocks read
+}
+
+// sampledChunk is what one chunk contributes.
+type sampledChunk struct {
+	text     []byte
+	kept     int64
+	usable   int
+	starts   int64
+	inflated int64
+}
+
+// openBlockSample returns a sampler for msg, or nil when the file should be
+// streamed whole instead: the job isn't a downsampled FASTQ job from a URL
+// (or is forced interleaved, whose pairs can't be found mid-file), the
+// server doesn't serve ranges with a size and validator, the file isn't BGZF,
+// or it holds too few reads for a sample to save much.
+func openBlockSample(ctx context.Context, msg QueueMessage) (*blockSampler, error) {
+	if !remoteBlockSampling || msg.SourceURL == "" || msg.DownsampleTarget <= 0 ||
+		msg.CountOnly || countOnlyDefault || msg.Interleaved {
+		return nil, nil
+	}
+	skip := func(reason string) (*blockSampler, error) {
+		zerolog.Ctx(ctx).Debug().Str("reason", reason).Msg("remote input read whole, not block-sampled")
+		return nil, nil
+	}
+	s := &blockSampler{ctx: ctx, url: msg.SourceURL, target: msg.DownsampleTarget, minLen: minReadLength}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, &qcError{Code: codeStorage, Msg: err.Error()}
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", blockSampleChunk+bgzfMaxBlock-1))
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, &qcError{Code: codeStorage, Msg: err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return skip("no range support")
+	}
+	if s.ifRange = resp.Header.Get("ETag"); s.ifRange == "" {
+		s.ifRange = resp.Header.Get("Last-Modified")
+	}
+	s.size = contentRangeSize(resp.Header.Get("Content-Range"))
+	switch {
+	case s.ifRange == "":
+		return skip("no validator")
+	case s.size <= blockSampleChunk:
+		return skip("fits in one chunk")
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &qcError{Code: codeStorage, Msg: fmt.Sprintf("reading remote: %v", err)}
+	}
+	if _, ok := bgzfBlockSize(data); !ok {
+		return skip("not BGZF")
+	}
+	if s.first, err = s.parseChunk(0, data); err != nil {
+		return nil, err
+	}
+	if t := bytes.TrimLeft(s.first.text, "\r\n"); len(t) == 0 || t[0] != '@' {
+		return skip("not FASTQ")
+	}
+	// the reads chunk 0 suggests the file holds, against what the sample needs
+	if s.first.inflated == 0 || float64(s.first.starts)*float64(s.size)/float64(s.first.inflated) < 2*float64(s.target) {
+		return skip("too few reads to sample")
+	}
+	chunks := int((s.size + blockSampleChunk - 1) / blockSampleChunk)
+	s.order = rand.New(rand.NewSource(msg.DownsampleSeed)).Perm(chunks)
+	zerolog.Ctx(ctx).Info().Int64("size_bytes", s.size).Int("chunks", chunks).Msg("block-sampling remote BGZF input")
+	return s, nil
+}
+
+// contentRangeSize returns the complete length from a Content-Range header
+// ("bytes 0-99/1234"), or -1 if it's missing or unknown.
+func contentRangeSize(h string) int64 {
+	_, total, ok := strings.Cut(h, "/")
+	if !ok || !strings.HasPrefix(h, "bytes ") {
+		return -1
+	}
+	n, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func (s *blockSampler) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.usable >= s.target || s.next == len(s.order) {
+			return 0, io.EOF
+		}
+		if err := s.advance(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *blockSampler) Close() error {
+	return nil
+}
+
+// advance moves on to the next chunk in the draw order.
+func (s *blockSampler) advance() error {
+	slot := s.order[s.next]
+	s.next++
+	c := s.first
+	if slot != 0 {
+		data, err := s.fetch(int64(slot) * blockSampleChunk)
+		if err != nil {
+			return err
+		}
+		if c, err = s.parseChunk(slot, data); err != nil {
+			return err
+		}
+	}
+	s.buf = c.text
+	s.kept += c.kept
+	s.usable += c.usable
+	s.starts += c.starts
+	s.inflated += c.inflated
+	return nil
+}
+
+// fetch reads the chunk at off plus its slack, retrying a request that
+// fails in transit up to remoteResumes times.
+func (s *blockSampler) fetch(off int64) ([]byte, error) {
+	end := off + blockSampleChunk + bgzfMaxBlock - 1
+	if end >= s.size {
+		end = s.size - 1
+	}
+	for attempt := 0; ; attempt++ {
+		data, err := s.get(off, end)
+		var qe *qcError
+		if err == nil || errors.As(err, &qe) || s.ctx.Err() != nil {
+			return data, err
+		}
+		if attempt == remoteResumes {
+			return nil, &qcError{Code: codeStorage, Msg: fmt.Sprintf("reading remote at byte %d: %v", off, err)}
+		}
+		zerolog.Ctx(s.ctx).Warn().Err(err).Int64("offset", off).Msg("remote range request failed; retrying")
+	}
+}
+
+// get requests bytes off..end. Failures in transit come back as plain
+// errors; answers that retrying won't change come back as a qcError.
+func (s *blockSampler) get(off, end int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, &qcError{Code: codeStorage, Msg: err.Error()}
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+	req.Header.Set("If-Range", s.ifRange)
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		// 200 to If-Range means the file changed since the first request
+		return nil, &qcError{Code: codeStorage, Msg: fmt.Sprintf("remote answered %s for bytes %d-%d", resp.Status, off, end)}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != end-off+1 {
+		return nil, fmt.Errorf("got %d bytes of %d", len(data), end-off+1)
+	}
+	return data, nil
+}
+
+// parseChunk inflates the blocks starting in chunk slot, whose bytes (with
+// slack) are data, and keeps the records wholly inside them.
+func (s *blockSampler) parseChunk(slot int, data []byte) (*sampledChunk, error) {
+	start := int64(slot) * blockSampleChunk
+	p := 0
+	if slot > 0 {
+		p = nextBGZFBlock(data)
+	}
+	c := &sampledChunk{}
+	var text []byte
+	for p >= 0 && int64(p) < blockSampleChunk && p < len(data) {
+		n, ok := bgzfBlockSize(data[p:])
+		if !ok {
+			return nil, badFormat("no BGZF block header at byte %d", start+int64(p))
+		}
+		if p+n > len(data) {
+			return nil, badFormat("BGZF block at byte %d runs past the end of the file", start+int64(p))
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data[p : p+n]))
+		if err != nil {
+			return nil, badFormat("corrupt gzip header at byte %d: %v", start+int64(p), err)
+		}
+		zr.Multistream(false)
+		b, err := io.ReadAll(&gzipErrReader{r: zr})
+		if err != nil {
+			return nil, err
+		}
+		text = append(text, b...)
+		c.inflated += int64(n)
+		p += n
+	}
+	eof := p >= 0 && start+int64(p) == s.size
+	c.text, c.kept, c.usable, c.starts = completeRecords(text, slot > 0, eof, s.minLen)
+	return c, nil
+}
+
+// bgzfBlockSize parses the BGZF header at the start of b (gzip with a "BC"
+// extra subfield holding BSIZE) and returns the whole block's length.
+func bgzfBlockSize(b []byte) (int, bool) {
+	const fextra = 0x04
+	if len(b) < 12 || !bytes.Equal(b[:3], gzipMagic) || b[3]&fextra == 0 {
+		return 0, false
+	}
+	xlen := int(binary.LittleEndian.Uint16(b[10:12]))
+	if len(b) < 12+xlen {
+		return 0, false
+	}
+	for x := b[12 : 12+xlen]; len(x) >= 4; {
+		slen := int(binary.LittleEndian.Uint16(x[2:4]))
+		if x[0] == 'B' && x[1] == 'C' && slen == 2 && len(x) >= 6 {
+			return int(binary.LittleEndian.Uint16(x[4:6])) + 1, true
+		}
+		if len(x) < 4+slen {
+			break
+		}
+		x = x[4+slen:]
+	}
+	return 0, false
+}
+
+// nextBGZFBlock finds the first block header in b that another header (or
+// the end of b) follows where its BSIZE says, so compressed bytes that
+// happen to look like a header aren't taken for one. -1 means none.
+func nextBGZFBlock(b []byte) int {
+	for i := 0; ; i++ {
+		j := bytes.Index(b[i:], gzipMagic)
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if n, ok := bgzfBlockSize(b[i:]); ok {
+			if i+n >= len(b) {
+				return i
+			}
+			if _, ok := bgzfBlockSize(b[i+n:]); ok {
+				return i
+			}
+		}
+	}
+}
+
+// completeRecords trims a chunk's text to the FASTQ records wholly inside
+// it, returning them with how many there are, how many are at least minLen
+// long and how many records start in the text, kept or not (so a chunk's
+// share of the file's reads can be estimated). Text from partway through the
+// file is resynced to the first record boundary first; only a chunk that
+// reaches the end of the file may keep a final line without a newline. If
+// the headers mark mates, a leading mate 2 and a trailing mate 1 are dropped
+// so pairs stay together.
+func completeRecords(text []byte, resync, eof bool, minLen int) ([]byte, int64, int, int64) {
+	i := 0
+	if resync {
+		if i = fastqSync(text); i < 0 {
+			return nil, 0, 0, 0
+		}
+	}
+	type span struct {
+		start, end int
+		mate       int
+		seqLen     int
+	}
+	var recs []span
+	var starts int64
+	for {
+		for i < len(text) && (text[i] == '\n' || text[i] == '\r') {
+			i++
+		}
+		if i == len(text) {
+			break
+		}
+		starts++
+		l, end, ok := fastqLines(text, i, eof)
+		if !ok {
+			break
+		}
+		_, mate := mateOf(string(l[0]))
+		recs = append(recs, span{start: i, end: end, mate: mate, seqLen: len(l[1])})
+		i = end
+	}
+	if len(recs) > 0 && recs[0].mate == 2 {
+		recs = recs[1:]
+	}
+	if len(recs) > 0 && recs[len(recs)-1].mate == 1 {
+		recs = recs[:len(recs)-1]
+	}
+	if len(recs) == 0 {
+		return nil, 0, 0, starts
+	}
+	out := text[recs[0].start:recs[len(recs)-1].end]
+	if out[len(out)-1] != '\n' {
+		out = append(out[:len(out):len(out)], '\n')
+	}
+	var usable int
+	for _, r := range recs {
+		if r.seqLen >= minLen {
+			usable++
+		}
+	}
+	return out, int64(len(recs)), usable, starts
+}
+
+// fastqLines splits the four lines of the record at text[i:], without line
+// endings, and returns where the record ends. ok is false if text ends
+// before the record does; with eof, a last line without a newline counts.
+func fastqLines(text []byte, i int, eof bool) (l [4][]byte, end int, ok bool) {
+	for k := range l {
+		j := bytes.IndexByte(text[i:], '\n')
+		switch {
+		case j >= 0:
+			l[k], i = text[i:i+j], i+j+1
+		case eof && k == 3 && i < len(text):
+			l[k], i = text[i:], len(text)
+		default:
+			return l, 0, false
+		}
+		l[k] = bytes.TrimSuffix(l[k], []byte{'\r'})
+	}
+	return l, i, true
+}
+
+// fastqSync returns the start of the first whole record in text that begins
+// mid-file: a line starting with '@' whose record's third line starts with
+// '+' and whose sequence and quality lengths match. A quality line may
+// start with '@' too, but the line two below it is a sequence, never a '+'.
+// -1 means text holds no boundary.
+func fastqSync(text []byte) int {
+	i := bytes.IndexByte(text, '\n')
+	for i >= 0 && i+1 < len(text) {
+		i++
+		if text[i] == '@' {
+			if l, _, ok := fastqLines(text, i, false); ok && len(l[2]) > 0 && l[2][0] == '+' && len(l[1]) == len(l[3]) {
+				return i
+			}
+		}
+		j := bytes.IndexByte(text[i:], '\n')
+		if j < 0 {
+			return -1
+		}
+		i += j
+	}
+	return -1
+}
+
+// progress is the share of the reads the sample needs handed over so far.
+func (s *blockSampler) progress() *float64 {
+	p := math.Min(100, float64(s.usable)*100/float64(s.target))
+	return &p
+}
+
+// finish scales res.InputReads, which counts the reads seen in the chunks
+// read, up to an estimate for the whole file: by the records starting in
+// those chunks per record kept, and by the file's size per compressed byte
+// inflated.
+func (s *blockSampler) finish(res *QCResult) {
+	if !res.Downsampled || s.kept == 0 || s.inflated == 0 {
+		return
+	}
+	scale := float64(s.starts) / float64(s.kept) * float64(s.size) / float64(s.inflated)
+	res.InputReads = int64(math.Round(float64(res.InputReads) * scale))
+	res.InputReadsEstimated = true
+	zerolog.Ctx(s.ctx).Info().Int("chunks_read", s.next).Int("chunks", len(s.order)).
+		Int64("input_reads", res.InputReads).Msg("block sample done; input_reads estimated")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// bgzfBlock compresses text as one BGZF block, patching BSIZE in once the
+// compressed size is known.
+func bgzfBlock(t *testing.T, text string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Header.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+	if _, err := zw.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	block := b.Bytes()
+	n := len(block) - 1
+	block[16], block[17] = byte(n), byte(n>>8)
+	return block
+}
+
+// bgzfFASTQ builds a BGZF file of 50bp reads; their qualities include '@'
+// so resyncing can't rely on it only starting headers.
+func bgzfFASTQ(t *testing.T, reads int) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	var file, text bytes.Buffer
+	for i := 0; i < reads; i++ {
+		seq, qual := make([]byte, 50), make([]byte, 50)
+		for j := range seq {
+			seq[j] = "ACGT"[rng.Intn(4)]
+			qual[j] = "@AI5"[rng.Intn(4)]
+		}
+		fmt.Fprintf(&text, "@r%d\n%s\n+\n%s\n", i, seq, qual)
+		// cut blocks mid-record, as bgzip does
+		if text.Len() > 3000 {
+			file.Write(bgzfBlock(t, text.String()[:3000]))
+			rest := text.String()[3000:]
+			text.Reset()
+			text.WriteString(rest)
+		}
+	}
+	file.Write(bgzfBlock(t, text.String()))
+	file.Write(bgzfBlock(t, "")) // EOF marker
+	return file.Bytes()
+}
+
+// serveRanges serves data with range support, counting the bytes sent.
+func serveRanges(t *testing.T, data []byte, sent *int64) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		cw := &countingWriter{ResponseWriter: w, n: sent}
+		http.ServeContent(cw, r, "s.fastq.gz", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.n, int64(len(p)))
+	return c.ResponseWriter.Write(p)
+}
+
+func TestBlockSample(t *testing.T) {
+	defer func(c int64) { blockSampleChunk = c }(blockSampleChunk)
+	blockSampleChunk = 16 << 10
+	const reads = 20000
+	data := bgzfFASTQ(t, reads)
+	var sent int64
+	msg := QueueMessage{SourceURL: serveRanges(t, data, &sent), DownsampleTarget: 300, DownsampleSeed: 7}
+
+	run := func() *QCResult {
+		t.Helper()
+		bs, err := openBlockSample(context.Background(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bs == nil {
+			t.Fatal("not block-sampled")
+		}
+		opts := testOptions()
+		opts.DownsampleTarget, opts.DownsampleSeed = msg.DownsampleTarget, msg.DownsampleSeed
+		res, err := computeQC(context.Background(), bs, opts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs.finish(res)
+		return res
+	}
+	res := run()
+	if res.Reads != 300 || !res.InputReadsEstimated {
+		t.Fatalf("%d reads, estimated %v; want a 300-read sample with an estimated input", res.Reads, res.InputReadsEstimated)
+	}
+	if res.InputReads < reads*9/10 || res.InputReads > reads*11/10 {
+		t.Errorf("input_reads estimate %d, want about %d", res.InputReads, reads)
+	}
+	if res.LengthMismatches != 0 || res.InvalidChars != 0 || res.TotalBases != 300*50 {
+		t.Errorf("sample misparsed: %d length mismatches, %d invalid, %d bases", res.LengthMismatches, res.InvalidChars, res.TotalBases)
+	}
+	if sent >= int64(len(data))/2 {
+		t.Errorf("fetched %d of %d bytes", sent, len(data))
+	}
+	if again := run(); again.InputReads != res.InputReads || again.GCCount != res.GCCount {
+		t.Error("same seed drew a different sample")
+	}
+}
+
+func TestBlockSampleFallback(t *testing.T) {
+	defer func(c int64) { blockSampleChunk = c }(blockSampleChunk)
+	blockSampleChunk = 16 << 10
+	var sent int64
+	bgzf := bgzfFASTQ(t, 20000)
+	plain := gzipMember(t, strings.Repeat("@r\nACGT\n+\nIIII\n", 20000))
+	for name, msg := range map[string]QueueMessage{
+		"plain gzip":      {SourceURL: serveRanges(t, plain, &sent), DownsampleTarget: 300},
+		"target too big":  {SourceURL: serveRanges(t, bgzf, &sent), DownsampleTarget: 15000},
+		"not downsampled": {SourceURL: serveRanges(t, bgzf, &sent)},
+	} {
+		bs, err := openBlockSample(context.Background(), msg)
+		if err != nil || bs != nil {
+			t.Errorf("%s: sampler %v, err %v; want the whole file streamed", name, bs != nil, err)
+		}
+	}
+}
+
+func TestFastqSync(t *testing.T) {
+	// starts inside r1's quality, whose next line begins with '@'
+	text := []byte("II\n@II\n@r2\nACGT\n+\nIIII\n")
+	if i := fastqSync(text); string(text[i:i+3]) != "@r2" {
+		t.Fatalf("synced to %q, want @r2", text[i:])
+	}
+	out, kept, _, starts := completeRecords([]byte("GT\n+\nIIII\n@r2/1\nAC\n+\nII\n@r2/2\nAC\n+\nII\n@r3/1\nAC\n+\nII\n@r3/2\nA"), true, false, 0)
+	if string(out) != "@r2/1\nAC\n+\nII\n@r2/2\nAC\n+\nII\n" || kept != 2 || starts != 4 {
+		t.Errorf("kept %q (%d of %d starts), want the r2 pair only", out, kept, starts)
+	}
+}
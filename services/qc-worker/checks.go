@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strconv"
+)
+
+// qcThresholds encode the lab SOP for a passing run. Contents are fractions
+// (0.05 = 5%), matching how gc_content and n_content are stored.
+type qcThresholds struct {
+	MaxNContent float64
+	GCMin       float64
+	GCMax       float64
+	// ExpectedGC is the organism's typical GC (EXPECTED_GC); nil when the
+	// lab hasn't set one. Deviating by more than GCTolerance raises a
+	// warning but doesn't affect qc_pass.
+	ExpectedGC  *float64
+	GCTolerance float64
+}
+
+func loadThresholds() (qcThresholds, error) {
+	t := qcThresholds{MaxNContent: 0.05, GCMin: 0.35, GCMax: 0.65, GCTolerance: 0.05}
+	var expected float64
+	for k, dst := range map[string]*float64{
+		"MAX_N_CONTENT": &t.MaxNContent,
+		"GC_MIN":        &t.GCMin,
+		"GC_MAX":        &t.GCMax,
+		"EXPECTED_GC":   &expected,
+		"GC_TOLERANCE":  &t.GCTolerance,
+	} {
+		v := os.Getenv(k)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return t, err
+		}
+		*dst = f
+		if k == "EXPECTED_GC" {
+			t.ExpectedGC = &expected
+		}
+	}
+	return t, nil
+}
+
+// evaluate returns whether a run with the given GC and N fractions passes
+// and the names of any failed checks. Failing QC doesn't fail the job; it's
+// surfaced for reviewers.
+func (t qcThresholds) evaluate(gc, n float64) (bool, []string) {
+	failed := []string{}
+	if n > t.MaxNContent {
+		failed = append(failed, "n_content_high")
+	}
+	if gc < t.GCMin {
+		failed = append(failed, "gc_content_low")
+	} else if gc > t.GCMax {
+		failed = append(failed, "gc_content_high")
+	}
+	return len(failed) == 0, failed
+}
+
+// gcWarning reports whether GC content is further than GCTolerance from
+// ExpectedGC, which hints at contamination or a sample swap. It's nil when
+// no expectation is configured.
+func (t qcThresholds) gcWarning(gc float64) *bool {
+	if t.ExpectedGC == nil {
+		return nil
+	}
+	w := math.Abs(gc-*t.ExpectedGC) > t.GCTolerance
+	return &w
+}
@@ -0,0 +1,82 @@
+package main
+
+import "math"
+
+// complexityAnalyzer measures sequence complexity as the Shannon entropy of
+// each read's A/C/G/T composition, in bits (0 for a homopolymer, 2 for equal
+// shares of all four bases). Only every sampleEvery'th read is scored,
+// which is plenty for a library-wide mean at a fraction of the cost.
+// Reads whose entropy is below minEntropy count as low complexity:
+// homopolymer runs, poly-A tails, simple dinucleotide repeats.
+type complexityAnalyzer struct {
+	sampleEvery int
+	minEntropy  float64
+	reads       int64
+	sampled     int64
+	sum         float64
+	low         int64
+}
+
+func newComplexityAnalyzer(opts qcOptions) Analyzer {
+	every := opts.ComplexitySampleEvery
+	if every < 1 {
+		every = 1
+	}
+	return &complexityAnalyzer{sampleEvery: every, minEntropy: opts.ComplexityMinEntropy}
+}
+
+func (a *complexityAnalyzer) Consume(rec *record) {
+	a.reads++
+	if (a.reads-1)%int64(a.sampleEvery) != 0 {
+		return
+	}
+	var counts [4]int
+	n := 0
+	for i := 0; i < len(rec.Seq); i++ {
+		switch rec.Seq[i] {
+		case 'A', 'a':
+			counts[0]++
+		case 'C', 'c':
+			counts[1]++
+		case 'G', 'g':
+			counts[2]++
+		case 'T', 't':
+			counts[3]++
+		default:
+			continue
+		}
+		n++
+	}
+	if n == 0 {
+		return // all N; nothing to score
+	}
+	h := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(n)
+			h -= p * math.Log2(p)
+		}
+	}
+	a.sampled++
+	a.sum += h
+	if h < a.minEntropy {
+		a.low++
+	}
+}
+
+// Finalize reports the mean entropy and low-complexity share of the scored
+// reads; both are null when no read was scored.
+func (a *complexityAnalyzer) Finalize() map[string]any {
+	out := map[string]any{
+		"mean_entropy":            nil,
+		"low_complexity_fraction": nil,
+		"min_entropy":             a.minEntropy,
+		"sampled_reads":           a.sampled,
+		"sample_every":            a.sampleEvery,
+	}
+	if a.sampled > 0 {
+		out["mean_entropy"] = a.sum / float64(a.sampled)
+		out["low_complexity_fraction"] = float64(a.low) / float64(a.sampled)
+	}
+	return out
+}
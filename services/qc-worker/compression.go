@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Compression formats recorded in qc_results.compression.
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	// compressionBGZF is blocked gzip (bgzip/htslib): a series of gzip
+	// members each carrying a "BC" extra subfield with the block size.
+	compressionBGZF = "bgzf"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// decompressSlots bounds how many inflate calls run at once across jobs,
+// backfills and self-tests (DECOMPRESS_CONCURRENCY); nil means no limit. A
+// slot is held for one Read rather than a whole stream, so parsing and the
+// analyzers never hold one and a slow consumer can't starve the others.
+var decompressSlots chan struct{}
+
+func newDecompressSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+// detectCompression classifies the stream from its first bytes without
+// consuming them.
+func detectCompression(br *bufio.Reader) string {
+	// gzip header (10) + XLEN (2) + first subfield id (2)
+	hdr, _ := br.Peek(14)
+	if len(hdr) < len(gzipMagic) || !bytes.Equal(hdr[:3], gzipMagic) {
+		return compressionNone
+	}
+	const fextra = 0x04
+	if len(hdr) == 14 && hdr[3]&fextra != 0 && hdr[12] == 'B' && hdr[13] == 'C' {
+		return compressionBGZF
+	}
+	return compressionGzip
+}
+
+// decompress returns the plain-text stream of r and the compression it
+// used, always detected from the magic bytes: a mislabeled file (a gzipped
+// sample.fastq, say) is read correctly whatever it was submitted as; see
+// checkDeclaredCompression. BGZF is valid multi-member gzip, so both go
+// through gzip.Reader.
+func decompress(r io.Reader) (io.Reader, string, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	kind := detectCompression(br)
+	if kind == compressionNone {
+		return br, kind, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, kind, badFormat("corrupt gzip header: %v", err)
+	}
+	// Concatenated members (cat a.gz b.gz, parallel compressors, BGZF) must
+	// all be read; this is gzip.Reader's default, set here so it stays that way.
+	zr.Multistream(true)
+	return &gzipErrReader{r: zr}, kind, nil
+}
+
+// gzipErrReader reports corrupt or truncated compressed data as BAD_FORMAT
+// rather than an internal error; failures reading the underlying storage
+// pass through unchanged.
+type gzipErrReader struct {
+	r io.Reader
+}
+
+func (g *gzipErrReader) Read(p []byte) (int, error) {
+	if decompressSlots != nil {
+		decompressSlots <- struct{}{}
+		defer func() { <-decompressSlots }()
+	}
+	n, err := g.r.Read(p)
+	var corrupt flate.CorruptInputError
+	if errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt) {
+		err = badFormat("corrupt gzip data: %v", err)
+	}
+	return n, err
+}
+
+// checkDeclaredCompression logs a warning for each claim about msg's file
+// that the detected compression contradicts: the submitter's compression
+// option, what ingress sniffed at submit and the filename's .gz extension.
+// None of them changes how the file is read.
+func checkDeclaredCompression(ctx context.Context, msg QueueMessage, detected string) {
+	warn := func(source, declared string) {
+		zerolog.Ctx(ctx).Warn().Str("source", source).Str("declared", declared).Str("detected", detected).
+			Msg("declared compression differs from the file's; using the detected one")
+	}
+	if msg.Compression != "" && msg.Compression != "auto" && msg.Compression != detected {
+		warn("compression option", msg.Compression)
+	}
+	if msg.DetectedCompression != "" && msg.DetectedCompression != detected {
+		warn("submit", msg.DetectedCompression)
+	}
+	if msg.Filename == "" {
+		return
+	}
+	name := strings.ToLower(msg.Filename)
+	gz := strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".bgz")
+	if gz != (detected != compressionNone) {
+		warn("extension", msg.Filename)
+	}
+}
+
+// recordCompression stores the detected compression as the job's
+// detected_compression, replacing ingress's guess (or filling it in for
+// jobs ingress never sniffed, such as URL submissions).
+func recordCompression(jobID, detected string) error {
+	_, err := db.Exec(`UPDATE jobs SET detected_compression=$2 WHERE id=$1 AND detected_compression IS DISTINCT FROM $2`, jobID, detected)
+	return err
+}
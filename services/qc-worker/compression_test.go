@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+)
+
+func gzipMember(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := io.WriteString(zw, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// TestDecompressMultiMember checks that every member of concatenated gzip
+// input (cat a.gz b.gz) is read, not just the first.
+func TestDecompressMultiMember(t *testing.T) {
+	a := "@r1\nACGT\n+\nIIII\n@r2\nGGCC\n+\nIIII\n"
+	b := "@r3\nAAAA\n+\nIIII\n"
+	input := append(gzipMember(t, a), gzipMember(t, b)...)
+
+	text, kind, err := decompress(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != compressionGzip {
+		t.Errorf("detected %q, want %q", kind, compressionGzip)
+	}
+	got, err := io.ReadAll(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != a+b {
+		t.Fatalf("decompressed %q, want %q", got, a+b)
+	}
+
+	text, _, err = decompress(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := computeQC(context.Background(), text, testOptions(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reads != 3 {
+		t.Errorf("%d reads, want 3 from both members", res.Reads)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// checkStrictConfig enforces CONFIG_STRICT=true: each named variable, and
+// UPLOAD_DIR for local storage, must be set explicitly instead of falling
+// back to a default that only suits the bundled docker-compose setup.
+// Every missing one is listed at once. (A local cold tier's COLD_UPLOAD_DIR
+// is required either way.)
+func checkStrictConfig(required ...string) error {
+	strict, err := strconv.ParseBool(env("CONFIG_STRICT", "false"))
+	if err != nil {
+		return fmt.Errorf("CONFIG_STRICT must be a boolean")
+	}
+	if !strict {
+		return nil
+	}
+	if env("STORAGE_BACKEND", "local") == "local" {
+		required = append(required, "UPLOAD_DIR")
+	}
+	var missing []string
+	for _, k := range required {
+		if os.Getenv(k) == "" {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("CONFIG_STRICT: required settings not set: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+)
+
+// detailStorage (DETAIL_STORAGE) picks where the per-position arrays go:
+// "rows" writes qc_per_base_content and the analyzer tables, "blob" packs
+// them into one gzipped JSON document in qc_results.qc_detail instead.
+// Blob keeps large jobs from adding thousands of rows, at the cost of not
+// being able to query single positions in SQL.
+var detailStorage string
+
+func parseDetailStorage(s string) (string, error) {
+	if s != "rows" && s != "blob" {
+		return "", fmt.Errorf("DETAIL_STORAGE must be rows or blob, got %q", s)
+	}
+	return s, nil
+}
+
+// detailBlob is the qc_detail document. Its shape matches results-api's
+// per_base_content, quality_dist and gc_distribution output, which decodes
+// it directly.
+type detailBlob struct {
+	PerBaseContent []baseContentRow `json:"per_base_content,omitempty"`
+	QualityDist    []qualityDistRow `json:"quality_dist,omitempty"`
+	GCDistribution []gcDistRow      `json:"gc_distribution,omitempty"`
+}
+
+type baseContentRow struct {
+	Position int     `json:"position"`
+	A        float64 `json:"a"`
+	C        float64 `json:"c"`
+	G        float64 `json:"g"`
+	T        float64 `json:"t"`
+}
+
+type qualityDistRow struct {
+	Position int     `json:"position"`
+	Counts   []int64 `json:"counts"`
+}
+
+type gcDistRow struct {
+	GCPercent int   `json:"gc_percent"`
+	Count     int64 `json:"count"`
+}
+
+// blobSaver is implemented by analyzers that can put their table's rows
+// in the detail blob instead when DETAIL_STORAGE=blob.
+type blobSaver interface {
+	detail(d *detailBlob)
+}
+
+// buildDetail collects res's per-position arrays and analyzer detail.
+// Positions no read reached (before a position_start) are left out, as in
+// qc_per_base_content.
+func buildDetail(res *QCResult) detailBlob {
+	d := detailBlob{}
+	for i, bc := range res.PerBase {
+		if bc.total() == 0 {
+			continue
+		}
+		a, c, g, t := bc.fractions()
+		d.PerBaseContent = append(d.PerBaseContent, baseContentRow{Position: i + 1, A: a, C: c, G: g, T: t})
+	}
+	for _, a := range res.analyzers {
+		if b, ok := a.(blobSaver); ok {
+			b.detail(&d)
+		}
+	}
+	return d
+}
+
+// encodeDetail returns the gzipped qc_detail document for res, or nil when
+// details are stored as rows, or only in qc_json (QC_JSON=only).
+func encodeDetail(res *QCResult) ([]byte, error) {
+	if detailStorage != "blob" || qcJSON == "only" {
+		return nil, nil
+	}
+	d := buildDetail(res)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(d); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// sampledRecord is a read kept by the reservoir, with its 0-based position
+// in the file (which also gives its mate slot in interleaved input).
+type sampledRecord struct {
+	rec   record
+	index int64
+}
+
+// reservoir keeps a uniform random sample of up to size records from a
+// stream of unknown length (Algorithm R). The same seed over the same input
+// always keeps the same reads.
+type reservoir struct {
+	size int
+	seed int64
+	rng  *rand.Rand
+	seen int64
+	kept []sampledRecord
+}
+
+func newReservoir(size int, seed int64) *reservoir {
+	return &reservoir{size: size, seed: seed, rng: rand.New(rand.NewSource(seed))}
+}
+
+// offer considers rec, the record at 0-based position index in the file.
+func (r *reservoir) offer(rec record, index int64) {
+	i := r.seen
+	r.seen++
+	if len(r.kept) < r.size {
+		r.kept = append(r.kept, sampledRecord{rec: rec, index: index})
+		return
+	}
+	if j := r.rng.Int63n(i + 1); j < int64(r.size) {
+		r.kept[j] = sampledRecord{rec: rec, index: index}
+	}
+}
+
+// sample returns the kept records in file order.
+func (r *reservoir) sample() []sampledRecord {
+	sort.Slice(r.kept, func(a, b int) bool { return r.kept[a].index < r.kept[b].index })
+	return r.kept
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Error codes stored in jobs.error_code so clients can tell bad input from
+// infrastructure trouble without parsing messages.
+const (
+	codeBadFormat = "BAD_FORMAT"
+	codeTimeout   = "TIMEOUT"
+	codeStorage   = "STORAGE_ERROR"
+	codeInternal  = "INTERNAL_ERROR"
+)
+
+type qcError struct {
+	Code string
+	Msg  string
+}
+
+func (e *qcError) Error() string {
+	return e.Code + ": " + e.Msg
+}
+
+func badFormat(format string, args ...any) error {
+	return &qcError{Code: codeBadFormat, Msg: fmt.Sprintf(format, args...)}
+}
+
+// classify splits err into the code and message recorded on the job.
+func classify(err error) (code, msg string) {
+	var qe *qcError
+	switch {
+	case errors.As(err, &qe):
+		return qe.Code, qe.Msg
+	case errors.Is(err, context.DeadlineExceeded):
+		return codeTimeout, err.Error()
+	default:
+		return codeInternal, err.Error()
+	}
+}
+
+// storageErr marks a failure to open the input as STORAGE_ERROR, keeping
+// any code it already carries.
+func storageErr(err error) error {
+	var qe *qcError
+	if errors.As(err, &qe) {
+		return err
+	}
+	return &qcError{Code: codeStorage, Msg: err.Error()}
+}
@@ -1,4 +1,568 @@
 package main
 
-// (kept minimal; logic is inline in main for clarity in this starter)
-// You can move FASTQ helpers here if you want to expand functionality.
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// baseCounts tallies nucleotides seen at a single read position.
+type baseCounts struct {
+	A, C, G, T, N, Other int64
+}
+
+func (b baseCounts) total() int64 {
+	return b.A + b.C + b.G + b.T + b.N + b.Other
+}
+
+// fractions returns the A/C/G/T share of all bases at the position.
+func (b baseCounts) fractions() (a, c, g, t float64) {
+	total := float64(b.total())
+	if total == 0 {
+		return 0, 0, 0, 0
+	}
+	return float64(b.A) / total, float64(b.C) / total, float64(b.G) / total, float64(b.T) / total
+}
+
+// positionCounts grows on demand, up to the MAX_POSITIONS cap the caller
+// applies through overflowPos.
+type positionCounts []baseCounts
+
+// overflowPos maps a 0-based position to its slot in a per-position array
+// capped at max: positions at or past max all share the overflow slot at
+// index max. A max of 0 leaves positions uncapped.
+func overflowPos(pos, max int) int {
+	if max > 0 && pos > max {
+		return max
+	}
+	return pos
+}
+
+func (p *positionCounts) add(pos int, base byte) {
+	if pos >= len(*p) {
+		*p = append(*p, make([]baseCounts, pos-len(*p)+1)...)
+	}
+	c := &(*p)[pos]
+	switch base {
+	case 'A', 'a':
+		c.A++
+	case 'C', 'c':
+		c.C++
+	case 'G', 'g':
+		c.G++
+	case 'T', 't':
+		c.T++
+	case 'N', 'n':
+		c.N++
+	default:
+		c.Other++
+	}
+}
+
+// seqStats are the core per-read counters, kept for the whole file and, for
+// interleaved input, separately per mate. WindowBases counts the bases
+// inside the position window, which GC and N content are relative to; with
+// no window it equals TotalBases.
+type seqStats struct {
+	Reads       int64
+	TotalBases  int64
+	WindowBases int64
+	GCCount     int64
+	NCount      int64
+}
+
+func (s *seqStats) AvgReadLength() float64 {
+	if s.Reads == 0 {
+		return 0
+	}
+	return float64(s.TotalBases) / float64(s.Reads)
+}
+
+func (s *seqStats) GCContent() float64 {
+	if s.WindowBases == 0 {
+		return 0
+	}
+	return float64(s.GCCount) / float64(s.WindowBases)
+}
+
+func (s *seqStats) NContent() float64 {
+	if s.WindowBases == 0 {
+		return 0
+	}
+	return float64(s.NCount) / float64(s.WindowBases)
+}
+
+// posWindow is a 0-based, half-open range [Start, End) of read positions;
+// End 0 means to the end of the read. The zero value covers every position.
+type posWindow struct {
+	Start, End int
+}
+
+func (w posWindow) contains(pos int) bool {
+	return pos >= w.Start && (w.End == 0 || pos < w.End)
+}
+
+// set reports whether the window restricts anything.
+func (w posWindow) set() bool {
+	return w.Start > 0 || w.End > 0
+}
+
+// QCResult holds the raw counters accumulated over a FASTQ stream.
+type QCResult struct {
+	seqStats
+	PerBase positionCounts
+	// Analyses holds each enabled analyzer's summary by name; analyzers
+	// keeps them for persisting their detail tables.
+	Analyses  map[string]map[string]any
+	analyzers []Analyzer
+
+	// Interleaved is set when records alternate R1/R2; Mates then holds the
+	// per-mate counters and PairNameMismatches counts consecutive records
+	// whose base read names differ.
+	Interleaved        bool
+	Mates              [2]seqStats
+	PairNameMismatches int64
+
+	// RunInfo is parsed from the first record's header.
+	RunInfo runInfo
+
+	// CountOnly results carry just Reads and TotalBases.
+	CountOnly bool
+
+	// Window restricts GC/N content, per-base composition and the quality
+	// histogram to a range of positions; read counts and lengths always
+	// cover the whole read.
+	Window posWindow
+
+	// MaxPositions is the per-position cap the arrays were built with; when
+	// positive, PerBase[MaxPositions] aggregates every later position.
+	MaxPositions int
+
+	// ShortReads counts the reads shorter than the MinReadLength filter,
+	// which are left out of Reads and every metric. records counts every
+	// record read, filtered or not, to locate reads in the file.
+	MinReadLength int
+	ShortReads    int64
+	records       int64
+
+	// Lengths holds the counted reads' lengths, for the read length N50.
+	Lengths lengthHist
+
+	// Truncated is set when the input ends inside a record (fewer than four
+	// lines, or a final quality line shorter than its sequence), as after an
+	// interrupted transfer. That record is left out of every metric.
+	Truncated bool
+
+	// InvalidChars counts sequence bytes that aren't IUPAC nucleotide codes;
+	// FirstInvalidRead/Pos (1-based) locate the first one.
+	InvalidChars     int64
+	FirstInvalidRead int64
+	FirstInvalidPos  int
+
+	// LengthMismatches counts FASTQ records whose quality line is longer or
+	// shorter than their sequence; FirstLengthMismatch (1-based) is the
+	// first. LengthChecked is false for FASTA, which has no quality lines.
+	LengthMismatches    int64
+	FirstLengthMismatch int64
+	LengthChecked       bool
+
+	// Compression is how the input was stored (compressionNone, ...); set
+	// by the caller, since computeQC only sees decompressed text.
+	Compression string
+
+	// Downsampled results describe a random sample of the reads: Reads and
+	// the metrics cover the sample, InputReads counts the whole file and
+	// DownsampleSeed reproduces the draw. InputReadsEstimated marks an
+	// InputReads extrapolated from the part of the file that was fetched
+	// (see blockSampler) rather than counted.
+	Downsampled         bool
+	InputReads          int64
+	DownsampleSeed      int64
+	InputReadsEstimated bool
+}
+
+// iupac marks the bytes allowed in a sequence line: nucleotide and
+// ambiguity codes in either case, plus '.' and '-' used for no-calls/gaps.
+var iupac = func() (t [256]bool) {
+	for _, c := range "ACGTURYSWKMBDHVN.-" {
+		t[c] = true
+		t[c|0x20] = true
+	}
+	return t
+}()
+
+// runInfo holds the Illumina instrument/run identifiers; fields the header
+// doesn't carry stay nil.
+type runInfo struct {
+	Instrument *string
+	RunID      *string
+	Flowcell   *string
+	Lane       *int
+}
+
+// parseRunInfo understands the Casava 1.8+ header
+// (@instrument:run:flowcell:lane:tile:x:y [read:filtered:control:index])
+// and the older @instrument:lane:tile:x:y#index/read form. Anything else
+// yields an empty runInfo.
+func parseRunInfo(header string) runInfo {
+	id, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(header), "@"), " ")
+	id, _, _ = strings.Cut(id, "#")
+	f := strings.Split(id, ":")
+	var ri runInfo
+	switch len(f) {
+	case 7:
+		lane, err := strconv.Atoi(f[3])
+		if err != nil || f[0] == "" {
+			return runInfo{}
+		}
+		ri.Instrument, ri.RunID, ri.Flowcell, ri.Lane = &f[0], &f[1], &f[2], &lane
+	case 5:
+		lane, err := strconv.Atoi(f[1])
+		if err != nil || f[0] == "" {
+			return runInfo{}
+		}
+		ri.Instrument, ri.Lane = &f[0], &lane
+	}
+	return ri
+}
+
+type qcOptions struct {
+	// Interleaved forces paired-end handling; otherwise it's auto-detected
+	// from /1 /2 (or Casava "1:" "2:") markers on the first two headers.
+	Interleaved bool
+	// CountOnly skips per-base classification and only counts reads and
+	// bases, for fast sanity checks on huge files.
+	CountOnly bool
+	// MaxInvalidChars fails the job as BAD_FORMAT once more non-IUPAC bytes
+	// than this are seen; negative disables the check.
+	MaxInvalidChars int64
+	// MaxLengthMismatches fails a FASTQ job as BAD_FORMAT once more records
+	// than this have quality and sequence of different lengths; negative
+	// only counts them.
+	MaxLengthMismatches int64
+	// MaxReadLength fails the job as BAD_FORMAT when a single sequence (or
+	// any line) is longer than this, instead of buffering a corrupt file's
+	// gigabyte "read" in memory.
+	MaxReadLength int
+	// Analyzers names the optional analyzers to run (see analyzerRegistry);
+	// they're skipped for count-only jobs.
+	Analyzers []string
+	// MaxPositions caps the per-position arrays (per-base content and the
+	// quality histogram); later positions are aggregated into one overflow
+	// position. 0 tracks every position.
+	MaxPositions int
+	// OverrepMaxDistinct caps the distinct sequences the overrepresented
+	// analyzer tracks.
+	OverrepMaxDistinct int
+	// ComplexitySampleEvery scores every Nth read for the complexity
+	// analyzer; ComplexityMinEntropy is its low-complexity cutoff in bits.
+	ComplexitySampleEvery int
+	ComplexityMinEntropy  float64
+	// DownsampleTarget, when positive, computes the metrics over that many
+	// reads drawn uniformly from the whole FASTQ file, using DownsampleSeed.
+	// Mate pairing and run info still come from every record.
+	DownsampleTarget int
+	DownsampleSeed   int64
+	// Window limits GC/N, per-base content and quality to those positions.
+	Window posWindow
+	// MinReadLength leaves reads shorter than this out of every metric,
+	// counting them as short reads instead; 0 keeps every read.
+	MinReadLength int
+}
+
+// mateOf splits a FASTQ header into its base read name and mate number
+// (1 or 2), or 0 if the header carries no mate marker.
+func mateOf(header string) (string, int) {
+	header = strings.TrimPrefix(strings.TrimSpace(header), "@")
+	name, rest, _ := strings.Cut(header, " ")
+	if strings.HasSuffix(name, "/1") || strings.HasSuffix(name, "/2") {
+		return name[:len(name)-2], int(name[len(name)-1] - '0')
+	}
+	// Casava 1.8+: "@name 1:N:0:ATCACG"
+	if len(rest) >= 2 && (rest[0] == '1' || rest[0] == '2') && rest[1] == ':' {
+		return name, int(rest[0] - '0')
+	}
+	return name, 0
+}
+
+// addSequence counts one read's bases (or one line of a multi-line FASTA
+// record starting at offset) into the totals, per-position counters and m.
+// Invalid bytes are looked for everywhere; composition only inside
+// res.Window.
+func (res *QCResult) addSequence(seq string, offset int, m *seqStats) {
+	res.TotalBases += int64(len(seq))
+	m.TotalBases += int64(len(seq))
+	for i := 0; i < len(seq); i++ {
+		if !iupac[seq[i]] {
+			if res.InvalidChars == 0 {
+				res.FirstInvalidRead, res.FirstInvalidPos = res.records, offset+i+1
+			}
+			res.InvalidChars++
+		}
+		if !res.Window.contains(offset + i) {
+			continue
+		}
+		res.WindowBases++
+		m.WindowBases++
+		switch seq[i] {
+		case 'G', 'g', 'C', 'c':
+			res.GCCount++
+			m.GCCount++
+		case 'N', 'n':
+			res.NCount++
+			m.NCount++
+		}
+		res.PerBase.add(overflowPos(offset+i, res.MaxPositions), seq[i])
+	}
+}
+
+// sniffFormat skips leading whitespace and returns the first byte without
+// consuming it. Anything other than '@' (FASTQ) or '>' (FASTA) is rejected
+// up front, so a BAM or PDF upload fails loudly instead of producing
+// meaningless GC numbers. An empty stream is treated as an empty FASTQ.
+func sniffFormat(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return '@', nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '@', '>':
+			return b, br.UnreadByte()
+		default:
+			return 0, badFormat("input is not FASTQ or FASTA (first byte %q)", b)
+		}
+	}
+}
+
+// computeFASTA counts FASTA records. FASTA carries no mate or run
+// information, and downsampling doesn't apply.
+func computeFASTA(ctx context.Context, it recordIterator, opts qcOptions, progress func(res *QCResult)) (*QCResult, error) {
+	res := &QCResult{CountOnly: opts.CountOnly, Window: opts.Window, MaxPositions: opts.MaxPositions, MinReadLength: opts.MinReadLength,
+		Lengths: lengthHist{}}
+	p := newPipeline(opts)
+	collect := !opts.CountOnly && !p.empty()
+	var scratch seqStats
+	for {
+		rec, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.records++
+		switch {
+		case len(rec.Seq) < opts.MinReadLength:
+			res.ShortReads++
+		case opts.CountOnly:
+			res.Reads++
+			res.TotalBases += int64(len(rec.Seq))
+			res.Lengths.add(len(rec.Seq))
+		default:
+			res.Reads++
+			res.addSequence(rec.Seq, 0, &scratch)
+			res.Lengths.add(len(rec.Seq))
+			if collect {
+				p.consume(rec)
+			}
+		}
+		if err := checkpoint(ctx, res, progress); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkInvalidChars(res, opts); err != nil {
+		return nil, err
+	}
+	if collect {
+		res.Analyses, res.analyzers = p.finalize(), p.analyzers
+	}
+	return res, nil
+}
+
+func checkInvalidChars(res *QCResult, opts qcOptions) error {
+	if opts.MaxInvalidChars >= 0 && res.InvalidChars > opts.MaxInvalidChars {
+		return badFormat("%d invalid sequence characters (first at read %d, position %d)",
+			res.InvalidChars, res.FirstInvalidRead, res.FirstInvalidPos)
+	}
+	return nil
+}
+
+// trimEOL normalizes a scanned line the same way for every FASTQ/FASTA
+// field. bufio.ScanLines already drops the CR of a CRLF ending; this also
+// strips repeated CRs (from double conversion) and trailing blanks, so a
+// sequence and its quality line always compare on the same terms regardless
+// of where the file was produced.
+func trimEOL(line string) string {
+	return strings.TrimRight(line, " \t\r")
+}
+
+// progressEvery is how many records are read between ctx/progress checks.
+const progressEvery = 10000
+
+// checkpoint periodically aborts a job past its deadline and reports
+// progress.
+func checkpoint(ctx context.Context, res *QCResult, progress func(res *QCResult)) error {
+	if res.records%progressEvery != 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("job timed out after %d reads: %w", res.records, err)
+	}
+	if progress != nil {
+		progress(res)
+	}
+	return nil
+}
+
+// computeQC parses a FASTQ (or FASTA) stream and accumulates QC counters.
+// progress, if non-nil, is called periodically with the counters so far; it
+// must not keep res past the call.
+func computeQC(ctx context.Context, r io.Reader, opts qcOptions, progress func(res *QCResult)) (*QCResult, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	first, err := sniffFormat(br)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := bufio.NewScanner(br)
+	// grow the buffer up to MaxReadLength for long reads; the slack leaves
+	// room for a CRLF so a read of exactly the limit still fits
+	buf := make([]byte, 0, 64*1024)
+	sc.Buffer(buf, opts.MaxReadLength+2)
+	it := newRecordIterator(first, sc, opts.MaxReadLength)
+
+	if first == '>' {
+		return computeFASTA(ctx, it, opts, progress)
+	}
+
+	res := &QCResult{Window: opts.Window, MaxPositions: opts.MaxPositions, MinReadLength: opts.MinReadLength, LengthChecked: true,
+		Lengths: lengthHist{}}
+	p := newPipeline(opts)
+	collect := !opts.CountOnly && !p.empty()
+	var rs *reservoir
+	if opts.DownsampleTarget > 0 && !opts.CountOnly {
+		rs = newReservoir(opts.DownsampleTarget, opts.DownsampleSeed)
+	}
+	var detected bool
+	var firstMate int
+	var prevName string
+	for {
+		rec, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// this record's 0-based position in the file
+		idx := res.records
+		res.records++
+		if len(rec.Qual) != len(rec.Seq) {
+			if res.LengthMismatches == 0 {
+				res.FirstLengthMismatch = res.records
+			}
+			res.LengthMismatches++
+		}
+		short := len(rec.Seq) < opts.MinReadLength
+		if short {
+			res.ShortReads++
+		}
+		if opts.CountOnly {
+			if idx == 0 {
+				res.RunInfo = parseRunInfo(rec.Header)
+			}
+			if !short {
+				res.Reads++
+				res.TotalBases += int64(len(rec.Seq))
+				res.Lengths.add(len(rec.Seq))
+			}
+		} else {
+			// mates are paired by position, so short reads still count here
+			name, mate := mateOf(rec.Header)
+			switch idx {
+			case 0:
+				firstMate = mate
+				res.RunInfo = parseRunInfo(rec.Header)
+			case 1:
+				detected = firstMate == 1 && mate == 2 && name == prevName
+			}
+			if idx%2 == 1 && name != prevName {
+				res.PairNameMismatches++
+			}
+			prevName = name
+			switch {
+			case short:
+				// left out of every metric
+			case rs != nil:
+				// counted here, measured once the sample is drawn
+				res.Reads++
+				rs.offer(*rec, idx)
+			default:
+				m := &res.Mates[idx%2]
+				res.Reads++
+				m.Reads++
+				res.addSequence(rec.Seq, 0, m)
+				res.Lengths.add(len(rec.Seq))
+				if collect {
+					p.consume(rec)
+				}
+			}
+		}
+		if err := checkpoint(ctx, res, progress); err != nil {
+			return nil, err
+		}
+	}
+	if tr, ok := it.(truncationReporter); ok {
+		res.Truncated = tr.truncated()
+	}
+	if rs != nil {
+		res.measureSample(rs, p, collect)
+	}
+	if err := checkInvalidChars(res, opts); err != nil {
+		return nil, err
+	}
+	if opts.MaxLengthMismatches >= 0 && res.LengthMismatches > opts.MaxLengthMismatches {
+		return nil, badFormat("%d reads whose quality length differs from the sequence length (first at read %d)",
+			res.LengthMismatches, res.FirstLengthMismatch)
+	}
+	if collect {
+		res.Analyses, res.analyzers = p.finalize(), p.analyzers
+	}
+	res.CountOnly = opts.CountOnly
+	res.Interleaved = !opts.CountOnly && (opts.Interleaved || detected)
+	if !res.Interleaved {
+		res.Mates = [2]seqStats{}
+		res.PairNameMismatches = 0
+	}
+	return res, nil
+}
+
+// measureSample runs the sequence counters and analyzers over the reads the
+// reservoir kept, in file order, as if they were the whole input.
+func (res *QCResult) measureSample(rs *reservoir, p *pipeline, collect bool) {
+	res.Downsampled, res.InputReads, res.DownsampleSeed = true, res.Reads, rs.seed
+	sample := rs.sample()
+	for i := range sample {
+		s := &sample[i]
+		// addSequence locates invalid bytes by res.records, so point it at
+		// the read's position in the file
+		res.records = s.index + 1
+		m := &res.Mates[s.index%2]
+		m.Reads++
+		res.addSequence(s.rec.Seq, 0, m)
+		res.Lengths.add(len(s.rec.Seq))
+		if collect {
+			p.consume(&s.rec)
+		}
+	}
+	res.Reads = int64(len(sample))
+}
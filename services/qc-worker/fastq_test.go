@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// testOptions makes every input check strict.
+func testOptions() qcOptions {
+	return qcOptions{MaxReadLength: 1000, MaxInvalidChars: 0, MaxLengthMismatches: 0}
+}
+
+func TestComputeQCLineEndings(t *testing.T) {
+	const lf = "@r1\nACGTAC\n+\nIIIIII\n@r2\nGGNN\n+\nIIII\n@r3\nAAAAAAAA\n+\nIIIIIIII\n"
+	for name, input := range map[string]string{
+		"lf":    lf,
+		"crlf":  strings.ReplaceAll(lf, "\n", "\r\n"),
+		"mixed": strings.Replace(lf, "\n", "\r\n", 5),
+	} {
+		t.Run(name, func(t *testing.T) {
+			res, err := computeQC(context.Background(), strings.NewReader(input), testOptions(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Reads != 3 || res.TotalBases != 18 {
+				t.Errorf("reads %d, bases %d; want 3, 18", res.Reads, res.TotalBases)
+			}
+			if res.LengthMismatches != 0 {
+				t.Errorf("%d quality length mismatches (first at read %d), want 0", res.LengthMismatches, res.FirstLengthMismatch)
+			}
+			if res.InvalidChars != 0 {
+				t.Errorf("%d invalid characters, want 0", res.InvalidChars)
+			}
+			if got := res.Lengths.n50(); got != 6 {
+				t.Errorf("N50 %d, want 6", got)
+			}
+			if res.GCCount != 5 || res.NCount != 2 {
+				t.Errorf("GC %d, N %d; want 5, 2", res.GCCount, res.NCount)
+			}
+			if res.Truncated {
+				t.Error("truncated")
+			}
+		})
+	}
+}
+
+func TestComputeQCLengthMismatchCRLF(t *testing.T) {
+	// a real mismatch is still caught with CRLF endings
+	input := "@r1\r\nACGT\r\n+\r\nIIIII\r\n@r2\r\nACGT\r\n+\r\nIIII\r\n"
+	opts := testOptions()
+	opts.MaxLengthMismatches = -1
+	res, err := computeQC(context.Background(), strings.NewReader(input), opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.LengthMismatches != 1 || res.FirstLengthMismatch != 1 {
+		t.Errorf("%d mismatches, first at %d; want 1 at read 1", res.LengthMismatches, res.FirstLengthMismatch)
+	}
+	if _, err := computeQC(context.Background(), strings.NewReader(input), testOptions(), nil); err == nil {
+		t.Error("MaxLengthMismatches 0: want BAD_FORMAT")
+	}
+}
+
+func TestPerBaseContentWindowStart(t *testing.T) {
+	opts := testOptions()
+	opts.Window = posWindow{Start: 2}
+	res, err := computeQC(context.Background(), strings.NewReader("@r1\nACGTAC\n+\nIIIIII\n"), opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := buildDetail(res).PerBaseContent
+	if len(rows) != 4 || rows[0].Position != 3 || rows[3].Position != 6 {
+		t.Fatalf("per-base rows %+v, want positions 3-6 only", rows)
+	}
+	if rows[0].G != 1 {
+		t.Errorf("position 3: %+v, want all G", rows[0])
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "database/sql"
+
+// gcDistAnalyzer builds FastQC's "per sequence GC content": how many reads
+// have each GC percentage, in 1% bins (0 to 100). Genome-wide GC hides a
+// contaminant with a different GC, which shows up here as a second peak.
+// A read's GC is the share of G/C among its A/C/G/T bases inside the job's
+// position window; reads without any (all N) aren't counted.
+type gcDistAnalyzer struct {
+	window posWindow
+	counts [101]int64
+	reads  int64
+}
+
+func newGCDistAnalyzer(opts qcOptions) Analyzer {
+	return &gcDistAnalyzer{window: opts.Window}
+}
+
+func (a *gcDistAnalyzer) Consume(rec *record) {
+	seq := rec.Seq
+	if a.window.End > 0 && len(seq) > a.window.End {
+		seq = seq[:a.window.End]
+	}
+	gc, n := 0, 0
+	for i := a.window.Start; i < len(seq); i++ {
+		switch seq[i] {
+		case 'G', 'g', 'C', 'c':
+			gc++
+		case 'A', 'a', 'T', 't':
+		default:
+			continue
+		}
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	// rounded to the nearest percent, as FastQC bins it
+	a.counts[(200*gc+n)/(2*n)]++
+	a.reads++
+}
+
+// Finalize reports the reads counted and the histogram's mean and mode;
+// the bins themselves go to qc_gc_distribution.
+func (a *gcDistAnalyzer) Finalize() map[string]any {
+	if a.reads == 0 {
+		return map[string]any{"reads": 0}
+	}
+	var sum int64
+	mode := 0
+	for pct, c := range a.counts {
+		sum += int64(pct) * c
+		if c > a.counts[mode] {
+			mode = pct
+		}
+	}
+	return map[string]any{
+		"reads":        a.reads,
+		"mean_percent": float64(sum) / float64(a.reads),
+		"mode_percent": mode,
+	}
+}
+
+// save replaces the job's histogram, one row per non-empty bin.
+func (a *gcDistAnalyzer) save(tx *sql.Tx, jobID string) error {
+	var pct []int32
+	var count []int64
+	for p, c := range a.counts {
+		if c > 0 {
+			pct, count = append(pct, int32(p)), append(count, c)
+		}
+	}
+	if len(pct) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+INSERT INTO qc_gc_distribution (job_id, gc_percent, count)
+SELECT $1, * FROM unnest($2::int[], $3::bigint[])
+`, jobID, pct, count)
+	return err
+}
+
+// detail adds the histogram to the qc_detail blob in place of the
+// qc_gc_distribution rows.
+func (a *gcDistAnalyzer) detail(d *detailBlob) {
+	for p, c := range a.counts {
+		if c > 0 {
+			d.GCDistribution = append(d.GCDistribution, gcDistRow{GCPercent: p, Count: c})
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+// jobLogSink persists each log line written for a job into job_logs, so
+// results-api can show what happened without grepping aggregated logs.
+type jobLogSink struct {
+	jobID string
+}
+
+func (s jobLogSink) Write(p []byte) (int, error) {
+	// a failed insert must not fail the job; the line still reaches stderr
+	db.Exec(`INSERT INTO job_logs (job_id, line) VALUES ($1, $2::jsonb)`, s.jobID, string(p))
+	return len(p), nil
+}
+
+// jobLogger returns a logger tagged with the job id that writes to stderr
+// like the global logger and to the job's persisted log.
+func jobLogger(jobID string) zerolog.Logger {
+	w := io.MultiWriter(os.Stderr, jobLogSink{jobID: jobID})
+	return zerolog.New(w).With().Timestamp().Str("job_id", jobID).Logger()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+var kafkaDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "qc_kafka_results_dropped_total",
+	Help: "Finished-job results that never reached KAFKA_TOPIC, by reason",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(kafkaDropped)
+}
+
+// resultSink publishes each finished job's results to Kafka for consumers
+// outside RabbitMQ. It's nil unless KAFKA_BROKERS and KAFKA_TOPIC are set.
+var resultSink *kafkaSink
+
+// kafkaSink hands results to a background writer through a bounded
+// buffer. Neither a slow nor an unreachable cluster ever holds up a job:
+// once the buffer is full, further results are dropped and counted.
+type kafkaSink struct {
+	w       *kafka.Writer
+	pending chan kafka.Message
+}
+
+func loadKafkaSink() (*kafkaSink, error) {
+	brokers, topic := os.Getenv("KAFKA_BROKERS"), os.Getenv("KAFKA_TOPIC")
+	if brokers == "" || topic == "" {
+		return nil, nil
+	}
+	buffer, err := strconv.Atoi(env("KAFKA_BUFFER", "1000"))
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := time.ParseDuration(env("KAFKA_WRITE_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, b := range strings.Split(brokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			addrs = append(addrs, b)
+		}
+	}
+	s := &kafkaSink{
+		w: &kafka.Writer{
+			Addr:         kafka.TCP(addrs...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{}, // by job id, so a job's results keep their order
+			RequiredAcks: kafka.RequireAll,
+			WriteTimeout: timeout,
+		},
+		pending: make(chan kafka.Message, buffer),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *kafkaSink) run() {
+	for m := range s.pending {
+		ctx, cancel := context.WithTimeout(context.Background(), s.w.WriteTimeout)
+		err := s.w.WriteMessages(ctx, m)
+		cancel()
+		if err != nil {
+			kafkaDropped.WithLabelValues("write_error").Inc()
+			log.Warn().Err(err).Str("job_id", string(m.Key)).Msg("kafka publish error")
+		}
+	}
+}
+
+// publish queues a job's results without waiting for the cluster.
+func (s *kafkaSink) publish(msg QueueMessage, res *QCResult, elapsed time.Duration) {
+	body, err := json.Marshal(newResultEvent(msg, res, elapsed))
+	if err != nil {
+		log.Warn().Err(err).Str("job_id", msg.JobID).Msg("kafka result encode error")
+		return
+	}
+	select {
+	case s.pending <- kafka.Message{Key: []byte(msg.JobID), Value: body}:
+	default:
+		kafkaDropped.WithLabelValues("buffer_full").Inc()
+		log.Warn().Str("job_id", msg.JobID).Msg("kafka buffer full; result not published")
+	}
+}
+
+// resultEvent is the message value: the job's headline metrics, named as
+// results-api names them. Per-position arrays are left out; consumers that
+// want them fetch GET /job/{id}/qc.
+type resultEvent struct {
+	JobID         string                    `json:"job_id"`
+	Filename      string                    `json:"filename,omitempty"`
+	SHA256        string                    `json:"sha256,omitempty"`
+	Status        string                    `json:"status"`
+	CompletedAt   time.Time                 `json:"completed_at"`
+	Reads         int64                     `json:"reads"`
+	AvgReadLength float64                   `json:"avg_read_length"`
+	TotalBases    int64                     `json:"total_bases"`
+	GCContent     *float64                  `json:"gc_content"`
+	NContent      *float64                  `json:"n_content"`
+	QCPass        *bool                     `json:"qc_pass"`
+	FailedChecks  []string                  `json:"failed_checks"`
+	CountOnly     bool                      `json:"count_only"`
+	Interleaved   bool                      `json:"interleaved"`
+	Compression   string                    `json:"compression"`
+	Truncated     bool                      `json:"truncated"`
+	Analyses      map[string]map[string]any `json:"analyses,omitempty"`
+	ProcessingMS  int                       `json:"processing_ms"`
+}
+
+func newResultEvent(msg QueueMessage, res *QCResult, elapsed time.Duration) resultEvent {
+	ev := resultEvent{
+		JobID:         msg.JobID,
+		Filename:      msg.Filename,
+		SHA256:        msg.SHA256,
+		Status:        "done",
+		CompletedAt:   time.Now().UTC(),
+		Reads:         res.Reads,
+		AvgReadLength: res.AvgReadLength(),
+		TotalBases:    res.TotalBases,
+		CountOnly:     res.CountOnly,
+		Interleaved:   res.Interleaved,
+		Compression:   res.Compression,
+		Truncated:     res.Truncated,
+		Analyses:      res.Analyses,
+		ProcessingMS:  int(elapsed.Milliseconds()),
+	}
+	// the same evaluation saveResults stores; count-only runs have none
+	if !res.CountOnly {
+		gc, n := res.GCContent(), res.NContent()
+		pass, failed := thresholds.evaluate(gc, n)
+		ev.GCContent, ev.NContent, ev.QCPass, ev.FailedChecks = &gc, &n, &pass, failed
+	}
+	return ev
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Overrepresented sequences follow FastQC: reads longer than 75 bp are
+// keyed on their first 50 bp, and a sequence is reported once it makes up
+// at least 0.1% of the reads.
+const (
+	overrepKeyLength     = 50
+	overrepLongRead      = 75
+	overrepMinFraction   = 0.001
+	overrepReportedLimit = 20
+)
+
+// overrepAnalyzer counts identical read sequences to find adapters,
+// primer dimers and other contamination. At most maxDistinct keys are
+// tracked; after that only sequences already in the table are counted,
+// which keeps memory bounded on high-complexity libraries. Anything common
+// enough to report is nearly always seen early, so the result is a good
+// heavy-hitters approximation, flagged as such via "capped".
+type overrepAnalyzer struct {
+	maxDistinct int
+	counts      map[string]int64
+	reads       int64
+	capped      bool
+}
+
+func newOverrepAnalyzer(opts qcOptions) Analyzer {
+	return &overrepAnalyzer{maxDistinct: opts.OverrepMaxDistinct, counts: map[string]int64{}}
+}
+
+func (a *overrepAnalyzer) Consume(rec *record) {
+	a.reads++
+	key := rec.Seq
+	if len(key) > overrepLongRead {
+		// a substring would keep the whole read alive as a map key
+		key = strings.Clone(key[:overrepKeyLength])
+	}
+	if _, ok := a.counts[key]; !ok && len(a.counts) >= a.maxDistinct {
+		a.capped = true
+		return
+	}
+	a.counts[key]++
+}
+
+// overrepSeq is one reported sequence.
+type overrepSeq struct {
+	Sequence string  `json:"sequence"`
+	Count    int64   `json:"count"`
+	Fraction float64 `json:"fraction"`
+}
+
+func (a *overrepAnalyzer) Finalize() map[string]any {
+	seqs := []overrepSeq{}
+	for s, n := range a.counts {
+		if f := float64(n) / float64(a.reads); f >= overrepMinFraction {
+			seqs = append(seqs, overrepSeq{Sequence: s, Count: n, Fraction: f})
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		if seqs[i].Count != seqs[j].Count {
+			return seqs[i].Count > seqs[j].Count
+		}
+		return seqs[i].Sequence < seqs[j].Sequence
+	})
+	if len(seqs) > overrepReportedLimit {
+		seqs = seqs[:overrepReportedLimit]
+	}
+	return map[string]any{
+		"sequences":        seqs,
+		"distinct_tracked": len(a.counts),
+		"capped":           a.capped,
+	}
+}
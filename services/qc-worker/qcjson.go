@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// qcJSON (QC_JSON) controls qc_results.qc_json, the whole result as one
+// JSON document in results-api's qc shape: "off" doesn't write it, "also"
+// writes it next to the usual columns and tables, and "only" writes it
+// instead of the per-position, per-mate and run info rows and qc_detail.
+// The qc_results scalar columns are written either way, for filtering and
+// indexing; new metrics only need a field here.
+var qcJSON string
+
+func parseQCJSON(s string) (string, error) {
+	if s != "off" && s != "also" && s != "only" {
+		return "", fmt.Errorf("QC_JSON must be off, also or only, got %q", s)
+	}
+	return s, nil
+}
+
+// qcDoc is the qc_json document. Field names follow results-api's QC,
+// which reads the arrays, paired and run_info back from it.
+type qcDoc struct {
+	Reads          int64                     `json:"reads"`
+	AvgReadLength  float64                   `json:"avg_read_length"`
+	TotalBases     int64                     `json:"total_bases"`
+	GCContent      *float64                  `json:"gc_content"`
+	NContent       *float64                  `json:"n_content"`
+	ProcessingMS   int                       `json:"processing_ms"`
+	QCPass         *bool                     `json:"qc_pass"`
+	FailedChecks   []string                  `json:"failed_checks"`
+	Interleaved    bool                      `json:"interleaved"`
+	Paired         *qcDocPaired              `json:"paired,omitempty"`
+	RunInfo        qcDocRunInfo              `json:"run_info"`
+	CountOnly      bool                      `json:"count_only"`
+	InvalidChars   *int64                    `json:"invalid_char_count"`
+	FirstInvalid   *qcDocInvalid             `json:"first_invalid,omitempty"`
+	PeakMemBytes   *int64                    `json:"peak_mem_bytes"`
+	CPUMS          int64                     `json:"cpu_ms"`
+	ExpectedGC     *float64                  `json:"expected_gc"`
+	GCWarning      *bool                     `json:"gc_warning"`
+	Compression    string                    `json:"compression"`
+	Analyses       map[string]map[string]any `json:"analyses,omitempty"`
+	Downsample     *qcDocDownsample          `json:"downsample,omitempty"`
+	Truncated      bool                      `json:"truncated"`
+	PositionRange  *qcDocRange               `json:"position_range,omitempty"`
+	LengthMismatch *int64                    `json:"length_mismatch_count"`
+	MaxPositions   *int                      `json:"max_positions"`
+	ShortReads     *int64                    `json:"short_read_count"`
+	ReadN50        *int                      `json:"read_n50"`
+	detailBlob
+}
+
+type qcDocPaired struct {
+	R1             qcDocMate `json:"r1"`
+	R2             qcDocMate `json:"r2"`
+	NameMismatches int64     `json:"name_mismatches"`
+}
+
+type qcDocMate struct {
+	Reads         int64   `json:"reads"`
+	AvgReadLength float64 `json:"avg_read_length"`
+	GCContent     float64 `json:"gc_content"`
+	NContent      float64 `json:"n_content"`
+}
+
+type qcDocRunInfo struct {
+	Instrument *string `json:"instrument"`
+	RunID      *string `json:"run_id"`
+	Flowcell   *string `json:"flowcell"`
+	Lane       *int    `json:"lane"`
+}
+
+type qcDocInvalid struct {
+	Read     int64 `json:"read"`
+	Position int   `json:"position"`
+}
+
+type qcDocDownsample struct {
+	InputReads          int64 `json:"input_reads"`
+	SampledReads        int64 `json:"sampled_reads"`
+	Seed                int64 `json:"seed"`
+	InputReadsEstimated bool  `json:"input_reads_estimated"`
+}
+
+type qcDocRange struct {
+	Start int  `json:"start"`
+	End   *int `json:"end"`
+}
+
+// encodeQCJSON returns the qc_json document for res, or nil when QC_JSON is
+// off. It evaluates the same values saveResults stores in the columns.
+func encodeQCJSON(res *QCResult, ms int, usage resourceUsage) ([]byte, error) {
+	if qcJSON == "off" {
+		return nil, nil
+	}
+	ri := res.RunInfo
+	doc := qcDoc{
+		Reads:         res.Reads,
+		AvgReadLength: res.AvgReadLength(),
+		TotalBases:    res.TotalBases,
+		ProcessingMS:  ms,
+		Interleaved:   res.Interleaved,
+		RunInfo:       qcDocRunInfo{ri.Instrument, ri.RunID, ri.Flowcell, ri.Lane},
+		CountOnly:     res.CountOnly,
+		PeakMemBytes:  usage.PeakMemBytes,
+		CPUMS:         usage.CPUMS,
+		Compression:   res.Compression,
+		Analyses:      res.Analyses,
+		Truncated:     res.Truncated,
+		detailBlob:    buildDetail(res),
+	}
+	if !res.CountOnly {
+		gc, n := res.GCContent(), res.NContent()
+		pass, failed := thresholds.evaluate(gc, n)
+		doc.GCContent, doc.NContent, doc.QCPass, doc.FailedChecks = &gc, &n, &pass, failed
+		if doc.GCWarning = thresholds.gcWarning(gc); doc.GCWarning != nil {
+			doc.ExpectedGC = thresholds.ExpectedGC
+		}
+		doc.InvalidChars = &res.InvalidChars
+		if res.InvalidChars > 0 {
+			doc.FirstInvalid = &qcDocInvalid{Read: res.FirstInvalidRead, Position: res.FirstInvalidPos}
+		}
+	}
+	if res.Interleaved {
+		doc.Paired = &qcDocPaired{R1: newQCDocMate(&res.Mates[0]), R2: newQCDocMate(&res.Mates[1]), NameMismatches: res.PairNameMismatches}
+	}
+	if res.Downsampled {
+		doc.Downsample = &qcDocDownsample{InputReads: res.InputReads, SampledReads: res.Reads, Seed: res.DownsampleSeed,
+			InputReadsEstimated: res.InputReadsEstimated}
+	}
+	if res.Window.set() {
+		doc.PositionRange = &qcDocRange{Start: res.Window.Start}
+		if res.Window.End > 0 {
+			doc.PositionRange.End = &res.Window.End
+		}
+	}
+	if res.LengthChecked {
+		doc.LengthMismatch = &res.LengthMismatches
+	}
+	if res.MaxPositions > 0 {
+		doc.MaxPositions = &res.MaxPositions
+	}
+	if res.MinReadLength > 0 {
+		doc.ShortReads = &res.ShortReads
+	}
+	if res.Reads > 0 {
+		n50 := res.Lengths.n50()
+		doc.ReadN50 = &n50
+	}
+	return json.Marshal(doc)
+}
+
+func newQCDocMate(m *seqStats) qcDocMate {
+	return qcDocMate{Reads: m.Reads, AvgReadLength: m.AvgReadLength(), GCContent: m.GCContent(), NContent: m.NContent()}
+}
@@ -0,0 +1,115 @@
+package main
+
+import "database/sql"
+
+// Quality values are binned per position so downstream charts can draw
+// FastQC-style box plots without storing every score.
+const (
+	qualityBins     = 10
+	qualityBinWidth = 5 // bin i holds Q[5i, 5i+4]; the last bin also takes Q45 and up
+	phredOffset     = 33
+)
+
+// qualityDist is a per-position histogram of Phred scores. It grows up to the
+// position cap the caller enforces through overflowPos.
+type qualityDist [][qualityBins]int64
+
+func (d *qualityDist) add(pos int, c byte) {
+	if pos >= len(*d) {
+		*d = append(*d, make([][qualityBins]int64, pos-len(*d)+1)...)
+	}
+	q := int(c) - phredOffset
+	if q < 0 {
+		q = 0
+	}
+	bin := q / qualityBinWidth
+	if bin >= qualityBins {
+		bin = qualityBins - 1
+	}
+	(*d)[pos][bin]++
+}
+
+// binnedMaxValues is the most distinct Q values a binned run shows; 2-color
+// instruments (NovaSeq, NextSeq) emit about 4 and older binned HiSeqs 7 or 8,
+// while unbinned data spreads over 30 or more.
+const binnedMaxValues = 8
+
+// qualityAnalyzer builds the position × quality histogram saved to
+// qc_quality_dist. Positions at or past maxPos share one overflow
+// position, which bounds memory for very long reads. It also records which quality
+// characters appear anywhere in a read, to tell binned runs apart. Both
+// only look inside the job's position window.
+type qualityAnalyzer struct {
+	maxPos int
+	window posWindow
+	dist   qualityDist
+	seen   [256]bool
+}
+
+func newQualityAnalyzer(opts qcOptions) Analyzer {
+	return &qualityAnalyzer{maxPos: opts.MaxPositions, window: opts.Window}
+}
+
+func (a *qualityAnalyzer) Consume(rec *record) {
+	qual := rec.Qual
+	if a.window.End > 0 && len(qual) > a.window.End {
+		qual = qual[:a.window.End]
+	}
+	for i := a.window.Start; i < len(qual); i++ {
+		a.seen[qual[i]] = true
+	}
+	for i := a.window.Start; i < len(qual); i++ {
+		a.dist.add(overflowPos(i, a.maxPos), qual[i])
+	}
+}
+
+// Finalize reports the histogram shape plus quality_bins, the number of
+// distinct Q values observed, and whether that looks like a binned run.
+func (a *qualityAnalyzer) Finalize() map[string]any {
+	values := []int{}
+	for c, ok := range a.seen {
+		if ok {
+			values = append(values, c-phredOffset)
+		}
+	}
+	return map[string]any{
+		"positions":      len(a.dist),
+		"max_positions":  a.maxPos,
+		"bin_width":      qualityBinWidth,
+		"quality_bins":   len(values),
+		"quality_values": values,
+		"binned":         len(values) > 0 && len(values) <= binnedMaxValues,
+	}
+}
+
+// save replaces the job's histogram, one row per non-empty (position, bin)
+// with 1-based positions.
+func (a *qualityAnalyzer) save(tx *sql.Tx, jobID string) error {
+	var pos, bin []int32
+	var count []int64
+	for i, bins := range a.dist {
+		for b, n := range bins {
+			if n > 0 {
+				pos, bin, count = append(pos, int32(i+1)), append(bin, int32(b)), append(count, n)
+			}
+		}
+	}
+	if len(pos) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+INSERT INTO qc_quality_dist (job_id, position, bin, count)
+SELECT $1, * FROM unnest($2::int[], $3::int[], $4::bigint[])
+`, jobID, pos, bin, count)
+	return err
+}
+
+// detail adds the histogram to the qc_detail blob in place of the
+// qc_quality_dist rows, skipping empty positions as save does.
+func (a *qualityAnalyzer) detail(d *detailBlob) {
+	for i, bins := range a.dist {
+		if bins != ([qualityBins]int64{}) {
+			d.QualityDist = append(d.QualityDist, qualityDistRow{Position: i + 1, Counts: append([]int64(nil), bins[:]...)})
+		}
+	}
+}
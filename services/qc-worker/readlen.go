@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// lengthHist sums the bases of the reads of each length, for N50. Lengths
+// up to exactLengths are kept exactly; longer ones share buckets under 1%
+// wide (the length with all but its top 8 bits cleared), so a file of long
+// reads with millions of distinct lengths needs a few thousand buckets at
+// most.
+type lengthHist map[int]int64
+
+// exactLengths is the longest read length lengthHist doesn't bucket; short
+// read data falls entirely below it.
+const exactLengths = 1023
+
+func (h lengthHist) add(n int) {
+	bucket := n
+	if n > exactLengths {
+		shift := bits.Len(uint(n)) - 8
+		bucket = n >> shift << shift
+	}
+	h[bucket] += int64(n)
+}
+
+// n50 is the read length N50: the longest length such that reads at least
+// that long hold half the bases. It's exact for reads up to exactLengths
+// and rounded down to its bucket above; 0 when there are no reads.
+func (h lengthHist) n50() int {
+	lengths := make([]int, 0, len(h))
+	var total int64
+	for n, bases := range h {
+		lengths = append(lengths, n)
+		total += bases
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(lengths)))
+	var sum int64
+	for _, n := range lengths {
+		if sum += h[n]; 2*sum >= total {
+			return n
+		}
+	}
+	return 0
+}
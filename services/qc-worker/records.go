@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// recordIterator is the reading layer under computeQC: it turns the text
+// stream into records, so the counting loop doesn't care how records are
+// laid out. Next returns io.EOF after the last complete record; the record
+// it returns is only valid until the following call.
+type recordIterator interface {
+	Next() (*record, error)
+}
+
+// truncationReporter is implemented by iterators that can tell when the
+// input ended inside a record, which is then left out.
+type truncationReporter interface {
+	truncated() bool
+}
+
+// newRecordIterator picks the iterator for the format sniffFormat saw.
+func newRecordIterator(first byte, sc *bufio.Scanner, maxReadLength int) recordIterator {
+	if first == '>' {
+		return &fastaIterator{sc: sc, maxLen: maxReadLength}
+	}
+	return &fastqIterator{sc: sc, maxLen: maxReadLength}
+}
+
+// scanErr reports a scanner failure after n records, turning an over-long
+// line into a BAD_FORMAT error rather than an internal one.
+func scanErr(sc *bufio.Scanner, n int64, maxLen int) error {
+	err := sc.Err()
+	if err == bufio.ErrTooLong {
+		return badFormat("line after %d reads is longer than MAX_READ_LENGTH (%d)", n, maxLen)
+	}
+	return err
+}
+
+// fastqIterator reads four-line FASTQ records. A record is returned once
+// its quality line arrives, so a file cut off mid-record yields only its
+// complete records. A quality line shorter than its sequence is held back
+// until another line follows, since more would have come had the transfer
+// completed; if none does, that record is dropped too.
+type fastqIterator struct {
+	sc      *bufio.Scanner
+	maxLen  int
+	rec     record
+	n       int64  // records returned
+	ahead   string // line read past a short quality line
+	hasNext bool
+	partial bool
+}
+
+func (it *fastqIterator) line() (string, bool) {
+	if it.hasNext {
+		it.hasNext = false
+		return it.ahead, true
+	}
+	if !it.sc.Scan() {
+		return "", false
+	}
+	return trimEOL(it.sc.Text()), true
+}
+
+func (it *fastqIterator) Next() (*record, error) {
+	// 0: @header, 1: sequence, 2: +, 3: quality
+	var lines [4]string
+	for i := range lines {
+		l, ok := it.line()
+		if !ok {
+			if err := scanErr(it.sc, it.n, it.maxLen); err != nil {
+				return nil, err
+			}
+			// trailing blank lines aren't a partial record
+			for _, prev := range lines[:i] {
+				it.partial = it.partial || prev != ""
+			}
+			return nil, io.EOF
+		}
+		if i == 1 && len(l) > it.maxLen {
+			return nil, badFormat("read %d is longer than MAX_READ_LENGTH (%d)", it.n+1, it.maxLen)
+		}
+		lines[i] = l
+	}
+	if len(lines[3]) < len(lines[1]) {
+		l, ok := it.line()
+		if !ok {
+			if err := scanErr(it.sc, it.n, it.maxLen); err != nil {
+				return nil, err
+			}
+			it.partial = true
+			return nil, io.EOF
+		}
+		it.ahead, it.hasNext = l, true
+	}
+	it.n++
+	it.rec = record{Header: lines[0], Seq: lines[1], Qual: lines[3]}
+	return &it.rec, nil
+}
+
+func (it *fastqIterator) truncated() bool {
+	return it.partial
+}
+
+// fastaIterator reads '>'-headed records whose sequence may span several
+// lines, joined into one. A record longer in total than maxLen is
+// BAD_FORMAT, the same limit a FASTQ read has per line.
+type fastaIterator struct {
+	sc      *bufio.Scanner
+	maxLen  int
+	rec     record
+	seq     strings.Builder
+	n       int64
+	header  string // next record's header, already read
+	hasNext bool
+}
+
+func (it *fastaIterator) Next() (*record, error) {
+	// sniffFormat made sure nothing but blank lines precede the first header
+	for !it.hasNext {
+		if !it.sc.Scan() {
+			if err := scanErr(it.sc, it.n, it.maxLen); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		if l := trimEOL(it.sc.Text()); l != "" && l[0] == '>' {
+			it.header, it.hasNext = l, true
+		}
+	}
+	it.rec = record{Header: it.header}
+	it.hasNext = false
+	it.n++
+	it.seq.Reset()
+	for it.sc.Scan() {
+		l := trimEOL(it.sc.Text())
+		if l == "" {
+			continue
+		}
+		if l[0] == '>' {
+			it.header, it.hasNext = l, true
+			break
+		}
+		if it.seq.Len()+len(l) > it.maxLen {
+			return nil, badFormat("read %d is longer than MAX_READ_LENGTH (%d)", it.n, it.maxLen)
+		}
+		it.seq.WriteString(l)
+	}
+	if err := scanErr(it.sc, it.n-1, it.maxLen); err != nil {
+		return nil, err
+	}
+	it.rec.Seq = it.seq.String()
+	return &it.rec, nil
+}
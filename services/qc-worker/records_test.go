@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// iterate runs the iterator computeQC would pick for input to the end.
+func iterate(t *testing.T, input string, maxLen int) ([]record, bool, error) {
+	t.Helper()
+	br := bufio.NewReader(strings.NewReader(input))
+	first, err := sniffFormat(br)
+	if err != nil {
+		return nil, false, err
+	}
+	sc := bufio.NewScanner(br)
+	sc.Buffer(nil, maxLen+2)
+	it := newRecordIterator(first, sc, maxLen)
+	var recs []record
+	for {
+		rec, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return recs, false, err
+		}
+		recs = append(recs, *rec)
+	}
+	var truncated bool
+	if tr, ok := it.(truncationReporter); ok {
+		truncated = tr.truncated()
+	}
+	return recs, truncated, nil
+}
+
+func TestRecordIterators(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      []record
+		truncated bool
+	}{
+		{
+			name:  "fastq",
+			input: "@r1\nACGT\n+\nIIII\n@r2\nGG\n+\nII\n",
+			want:  []record{{"@r1", "ACGT", "IIII"}, {"@r2", "GG", "II"}},
+		},
+		{
+			name:  "fastq crlf",
+			input: "@r1\r\nACGT\r\n+\r\nIIII\r\n@r2\r\nGG\r\n+\r\nII\r\n",
+			want:  []record{{"@r1", "ACGT", "IIII"}, {"@r2", "GG", "II"}},
+		},
+		{
+			name:  "fastq without final newline",
+			input: "@r1\nACGT\n+\nIIII",
+			want:  []record{{"@r1", "ACGT", "IIII"}},
+		},
+		{
+			name:  "fastq leading and trailing blank lines",
+			input: "\n\n@r1\nACGT\n+\nIIII\n\n\n",
+			want:  []record{{"@r1", "ACGT", "IIII"}},
+		},
+		{
+			name:      "fastq cut after the sequence",
+			input:     "@r1\nACGT\n+\nIIII\n@r2\nGG\n",
+			want:      []record{{"@r1", "ACGT", "IIII"}},
+			truncated: true,
+		},
+		{
+			name:      "fastq cut inside the last quality line",
+			input:     "@r1\nACGT\n+\nIIII\n@r2\nGGCC\n+\nII",
+			want:      []record{{"@r1", "ACGT", "IIII"}},
+			truncated: true,
+		},
+		{
+			// a following line shows the short quality wasn't cut off
+			name:  "fastq short quality mid-file",
+			input: "@r1\nACGT\n+\nII\n@r2\nGG\n+\nII\n",
+			want:  []record{{"@r1", "ACGT", "II"}, {"@r2", "GG", "II"}},
+		},
+		{
+			name:  "fasta multi-line",
+			input: ">s1 desc\nACGT\nACGT\n>s2\nGG\n",
+			want:  []record{{">s1 desc", "ACGTACGT", ""}, {">s2", "GG", ""}},
+		},
+		{
+			name:  "fasta crlf",
+			input: ">s1\r\nACGT\r\nAC\r\n\r\n>s2\r\nGG\r\n",
+			want:  []record{{">s1", "ACGTAC", ""}, {">s2", "GG", ""}},
+		},
+		{
+			name:  "fasta empty record",
+			input: ">s1\n>s2\nGG",
+			want:  []record{{">s1", "", ""}, {">s2", "GG", ""}},
+		},
+		{
+			name:  "empty input",
+			input: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, truncated, err := iterate(t, tt.input, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(recs, tt.want) {
+				t.Errorf("records %q, want %q", recs, tt.want)
+			}
+			if truncated != tt.truncated {
+				t.Errorf("truncated %v, want %v", truncated, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestRecordIteratorsMaxReadLength(t *testing.T) {
+	long := strings.Repeat("A", 11)
+	for name, input := range map[string]string{
+		"fastq":            "@r1\n" + long + "\n+\n" + strings.Repeat("I", 11) + "\n",
+		"fasta line":       ">s1\n" + long + "\n",
+		"fasta multi-line": ">s1\nAAAAAA\nAAAAA\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := iterate(t, input, 10)
+			var qe *qcError
+			if !errors.As(err, &qe) || qe.Code != codeBadFormat {
+				t.Fatalf("err %v, want BAD_FORMAT", err)
+			}
+		})
+	}
+	// the CR of a CRLF line doesn't count towards the limit
+	recs, _, err := iterate(t, "@r1\r\nAAAAAAAAAA\r\n+\r\nIIIIIIIIII\r\n", 10)
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("read of exactly MAX_READ_LENGTH with CRLF: %d records, err %v", len(recs), err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var uuidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// handleReevaluate serves POST /job/{id}/reevaluate: it re-applies this
+// worker's current thresholds to the job's stored gc_content and n_content
+// and rewrites qc_pass, failed_checks, gc_warning and expected_gc in place.
+// Nothing is read from storage or queued, so it's the cheap way to apply
+// retuned SOP thresholds to finished jobs.
+func handleReevaluate(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/job/"), "/reevaluate")
+	if !ok || !uuidRe.MatchString(id) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var gc, n *float64
+	err := db.QueryRow(`SELECT gc_content, n_content FROM qc_results WHERE job_id=$1`, id).Scan(&gc, &n)
+	if err == sql.ErrNoRows {
+		http.Error(w, "qc results not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if gc == nil || n == nil {
+		http.Error(w, "job has no composition metrics to evaluate (count-only run)", http.StatusConflict)
+		return
+	}
+
+	pass, failed := thresholds.evaluate(*gc, *n)
+	var expectedGC *float64
+	gcWarning := thresholds.gcWarning(*gc)
+	if gcWarning != nil {
+		expectedGC = thresholds.ExpectedGC
+	}
+	// qc_json (when the job has one; NULL || ... stays NULL) gets the same
+	// values, so direct readers of the document see them too
+	_, err = db.Exec(`UPDATE qc_results SET qc_pass=$2, failed_checks=$3, gc_warning=$4, expected_gc=$5,
+  qc_json = qc_json || jsonb_build_object('qc_pass', $2::boolean, 'failed_checks', to_jsonb($3::text[]),
+    'gc_warning', $4::boolean, 'expected_gc', $5::float8)
+WHERE job_id=$1`,
+		id, pass, failed, gcWarning, expectedGC)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	log.Info().Str("job_id", id).Bool("qc_pass", pass).Msg("re-evaluated thresholds")
+	if err := notifyJobChanged(id); err != nil {
+		// results-api's cache still expires the old response after RESULT_CACHE_TTL
+		log.Warn().Err(err).Str("job_id", id).Msg("notify job change")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		JobID        string   `json:"job_id"`
+		QCPass       bool     `json:"qc_pass"`
+		FailedChecks []string `json:"failed_checks"`
+		GCWarning    *bool    `json:"gc_warning"`
+		ExpectedGC   *float64 `json:"expected_gc"`
+	}{id, pass, failed, gcWarning, expectedGC})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// remoteClient fetches POST /submit/url inputs. There's no overall timeout:
+// a large file streams for as long as the job runs, bounded by JOB_TIMEOUT
+// through the request context. Redirects must stay on the submitted host,
+// which ingress checked against URL_ALLOWED_HOSTS.
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host != via[0].URL.Host {
+			return fmt.Errorf("redirect to another host (%s)", req.URL.Host)
+		}
+		if len(via) >= 10 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+// remoteResumes is how many times a dropped remote stream is picked up
+// again with a Range request before the job fails.
+const remoteResumes = 3
+
+// openInput opens a job's file: from storage, or streamed from its source
+// URL for jobs submitted with one.
+func openInput(ctx context.Context, msg QueueMessage) (io.ReadCloser, error) {
+	if msg.SourceURL == "" {
+		return store.Open(ctx, msg.Path)
+	}
+	r := &remoteReader{ctx: ctx, url: msg.SourceURL}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// remoteReader streams a file over HTTP without keeping a copy, front to
+// back; downsampled BGZF jobs that can fetch just part of the file go
+// through blockSampler instead. Ranges are only used to resume where a
+// dropped connection left off, and only if the server advertised them.
+// If-Range with the first response's validator makes sure the rest comes
+// from the same version of the file.
+type remoteReader struct {
+	ctx     context.Context
+	url     string
+	body    io.ReadCloser
+	off     int64
+	ranges  bool
+	ifRange string // ETag or Last-Modified of the first response
+	resumed int
+}
+
+func (r *remoteReader) open() error {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return &qcError{Code: codeStorage, Msg: err.Error()}
+	}
+	if r.off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.off))
+		req.Header.Set("If-Range", r.ifRange)
+	}
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return &qcError{Code: codeStorage, Msg: err.Error()}
+	}
+	switch {
+	case r.off == 0 && resp.StatusCode == http.StatusOK:
+		r.ranges = resp.Header.Get("Accept-Ranges") == "bytes"
+		if r.ifRange = resp.Header.Get("ETag"); r.ifRange == "" {
+			r.ifRange = resp.Header.Get("Last-Modified")
+		}
+		r.ranges = r.ranges && r.ifRange != ""
+	case r.off > 0 && resp.StatusCode == http.StatusPartialContent:
+	default:
+		resp.Body.Close()
+		msg := fmt.Sprintf("remote answered %s", resp.Status)
+		if r.off > 0 {
+			// 200 to a resume means the file changed underneath us
+			msg = fmt.Sprintf("remote answered %s when resuming at byte %d", resp.Status, r.off)
+		}
+		return &qcError{Code: codeStorage, Msg: msg}
+	}
+	r.body = resp.Body
+	return nil
+}
+
+func (r *remoteReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.off += int64(n)
+	if err == nil || err == io.EOF || r.ctx.Err() != nil {
+		return n, err
+	}
+	if !r.ranges || r.resumed >= remoteResumes {
+		return n, &qcError{Code: codeStorage, Msg: fmt.Sprintf("reading remote at byte %d: %v", r.off, err)}
+	}
+	zerolog.Ctx(r.ctx).Warn().Err(err).Int64("offset", r.off).Msg("remote stream dropped; resuming")
+	r.body.Close()
+	r.resumed++
+	if err := r.open(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (r *remoteReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// resourceUsage is what one job cost the worker process. Deliveries are
+// handled one at a time, so process-wide counters are attributable to the
+// job that was running.
+type resourceUsage struct {
+	PeakMemBytes *int64 // nil when the peak couldn't be reset or read
+	CPUMS        int64
+}
+
+type usageMeter struct {
+	cpu       time.Duration
+	peakReset bool
+}
+
+func startUsage() *usageMeter {
+	// "5" resets VmHWM to the current RSS so the peak covers just this job
+	err := os.WriteFile("/proc/self/clear_refs", []byte("5"), 0)
+	return &usageMeter{cpu: cpuTime(), peakReset: err == nil}
+}
+
+func (m *usageMeter) stop() resourceUsage {
+	u := resourceUsage{CPUMS: (cpuTime() - m.cpu).Milliseconds()}
+	if m.peakReset {
+		if peak, ok := peakRSS(); ok {
+			u.PeakMemBytes = &peak
+		}
+	}
+	return u
+}
+
+// cpuTime is user plus system time consumed by the process so far.
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// peakRSS reads VmHWM (reported in kB) from /proc/self/status.
+func peakRSS() (int64, bool) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		rest, ok := strings.CutPrefix(sc.Text(), "VmHWM:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return kb * 1024, true
+	}
+	return 0, false
+}
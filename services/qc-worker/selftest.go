@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// selftestFASTQ is samples/tiny.fastq: two 20bp reads, 17 G/C and 2 N.
+const selftestFASTQ = `@SEQ_ID_1
+GATTTGGGGTTTAAAGGGCC
++
+IIIIIIIIIIIIIIIIIIII
+@SEQ_ID_2
+CCGTNNAATTAACCGGTTAA
++
+IIIIIIIIIIIIIIIIIIII
+`
+
+// selftestWant is what computeQC must report for selftestFASTQ.
+var selftestWant = selftestMetrics{Reads: 2, AvgReadLength: 20, GCContent: 0.425, NContent: 0.05, Compression: compressionGzip}
+
+type selftestMetrics struct {
+	Reads         int64   `json:"reads"`
+	AvgReadLength float64 `json:"avg_read_length"`
+	GCContent     float64 `json:"gc_content"`
+	NContent      float64 `json:"n_content"`
+	Compression   string  `json:"compression"`
+}
+
+// handleSelftest serves GET /selftest: it gzips the built-in sample, reads
+// it back through decompress and computeQC with this worker's settings and
+// checks the metrics against known values. Nothing touches Postgres,
+// RabbitMQ or storage, so a 200 says the binary itself computes correctly,
+// whatever the state of the infrastructure.
+func handleSelftest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	got, analyses, err := runSelftest(r.Context())
+	status, errMsg := http.StatusOK, ""
+	if err == nil {
+		err = checkSelftest(got)
+	}
+	if err != nil {
+		status, errMsg = http.StatusInternalServerError, err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		OK       bool                      `json:"ok"`
+		Error    string                    `json:"error,omitempty"`
+		Metrics  selftestMetrics           `json:"metrics"`
+		Expected selftestMetrics           `json:"expected"`
+		Analyses map[string]map[string]any `json:"analyses,omitempty"`
+	}{err == nil, errMsg, got, selftestWant, analyses})
+}
+
+func runSelftest(ctx context.Context) (selftestMetrics, map[string]map[string]any, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(selftestFASTQ))
+	zw.Close()
+	text, compression, err := decompress(&buf)
+	if err != nil {
+		return selftestMetrics{}, nil, err
+	}
+	res, err := computeQC(ctx, text, qcOptions{
+		MaxInvalidChars:       maxInvalidChars,
+		MaxLengthMismatches:   maxLengthMismatches,
+		MaxReadLength:         maxReadLength,
+		Analyzers:             enabledAnalyzers,
+		MaxPositions:          maxPositions,
+		OverrepMaxDistinct:    overrepMaxDistinct,
+		ComplexitySampleEvery: complexitySampleEvery,
+		ComplexityMinEntropy:  complexityMinEntropy,
+	}, nil)
+	if err != nil {
+		return selftestMetrics{}, nil, err
+	}
+	got := selftestMetrics{
+		Reads:         res.Reads,
+		AvgReadLength: res.AvgReadLength(),
+		GCContent:     res.GCContent(),
+		NContent:      res.NContent(),
+		Compression:   compression,
+	}
+	return got, res.Analyses, nil
+}
+
+func checkSelftest(got selftestMetrics) error {
+	const eps = 1e-9
+	switch {
+	case got.Compression != selftestWant.Compression:
+		return fmt.Errorf("compression detected as %q, want %q", got.Compression, selftestWant.Compression)
+	case got.Reads != selftestWant.Reads:
+		return fmt.Errorf("reads = %d, want %d", got.Reads, selftestWant.Reads)
+	case math.Abs(got.AvgReadLength-selftestWant.AvgReadLength) > eps:
+		return fmt.Errorf("avg_read_length = %v, want %v", got.AvgReadLength, selftestWant.AvgReadLength)
+	case math.Abs(got.GCContent-selftestWant.GCContent) > eps:
+		return fmt.Errorf("gc_content = %v, want %v", got.GCContent, selftestWant.GCContent)
+	case math.Abs(got.NContent-selftestWant.NContent) > eps:
+		return fmt.Errorf("n_content = %v, want %v", got.NContent, selftestWant.NContent)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// jobStats pushes per-job metrics to a StatsD/DogStatsD agent, for setups
+// where /metrics can't be scraped. It's nil unless STATSD_ADDR is set.
+var jobStats *statsdClient
+
+// statsdClient sends each job's metrics as one UDP packet of DogStatsD
+// lines. It's best effort: a missing agent or a dropped packet never
+// affects the job, and nothing waits for an answer.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+	tags   []string // STATSD_TAGS, added to every metric
+}
+
+func loadStatsd() (*statsdClient, error) {
+	addr := os.Getenv("STATSD_ADDR")
+	if addr == "" {
+		return nil, nil
+	}
+	// UDP has no handshake, so this only resolves the address
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &statsdClient{conn: conn, prefix: env("STATSD_PREFIX", "fastq_qc.")}
+	for _, t := range strings.Split(os.Getenv("STATSD_TAGS"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			c.tags = append(c.tags, t)
+		}
+	}
+	return c, nil
+}
+
+// jobDone emits a finished job's duration, reads and, unless it was
+// count-only, GC and N content, tagged status:done.
+func (c *statsdClient) jobDone(res *QCResult, elapsed time.Duration) {
+	tags := c.jobTags("done", res.Compression)
+	lines := []string{
+		c.line("job.duration", fmt.Sprint(elapsed.Milliseconds()), "ms", tags),
+		c.line("job.reads", fmt.Sprint(res.Reads), "g", tags),
+	}
+	if !res.CountOnly {
+		lines = append(lines,
+			c.line("job.gc_content", fmt.Sprint(res.GCContent()), "g", tags),
+			c.line("job.n_content", fmt.Sprint(res.NContent()), "g", tags))
+	}
+	c.send(lines)
+}
+
+// jobFailed emits a failed job's duration, tagged status:error and its
+// error code. The compression is ingress's, since the file may never have
+// been opened.
+func (c *statsdClient) jobFailed(msg QueueMessage, err error, elapsed time.Duration) {
+	code, _ := classify(err)
+	compression := msg.DetectedCompression
+	if compression == "" {
+		compression = "unknown"
+	}
+	tags := append(c.jobTags("error", compression), "error_code:"+code)
+	c.send([]string{c.line("job.duration", fmt.Sprint(elapsed.Milliseconds()), "ms", tags)})
+}
+
+func (c *statsdClient) jobTags(status, compression string) []string {
+	return append(append([]string(nil), c.tags...), "status:"+status, "compression:"+compression)
+}
+
+func (c *statsdClient) line(name, value, kind string, tags []string) string {
+	return c.prefix + name + ":" + value + "|" + kind + "|#" + strings.Join(tags, ",")
+}
+
+func (c *statsdClient) send(lines []string) {
+	c.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := c.conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		log.Debug().Err(err).Msg("statsd send error")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Storage is where uploaded FASTQ files live. Keys are what ingress records
+// in jobs.path and sends in QueueMessage.Path; the worker opens the same key
+// through its own Storage.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// newStorage picks the backend from STORAGE_BACKEND (local or s3).
+func newStorage(ctx context.Context) (Storage, error) {
+	return newTierStorage(ctx, "")
+}
+
+func newTierStorage(ctx context.Context, prefix string) (Storage, error) {
+	switch backend := env(prefix+"STORAGE_BACKEND", "local"); backend {
+	case "local":
+		return &localStorage{root: env(prefix+"UPLOAD_DIR", "/data/uploads")}, nil
+	case "s3":
+		return newS3Storage(ctx, prefix)
+	default:
+		return nil, fmt.Errorf("unknown %sSTORAGE_BACKEND %q", prefix, backend)
+	}
+}
+
+// localStorage keeps files under root. Keys are relative to root; absolute
+// paths inside root (recorded by older versions) are accepted too.
+type localStorage struct {
+	root string
+}
+
+func (l *localStorage) path(key string) (string, error) {
+	p := key
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(l.root, key)
+	}
+	p = filepath.Clean(p)
+	rel, err := filepath.Rel(filepath.Clean(l.root), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("storage key %q escapes %s", key, l.root)
+	}
+	return p, nil
+}
+
+// Put writes the file and fsyncs it and its directory, so a job is never
+// enqueued for bytes that a crash could lose.
+func (l *localStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := out.ReadFrom(r); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(p))
+}
+
+func (l *localStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (l *localStorage) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// s3Storage stores objects under S3_PREFIX in S3_BUCKET. Credentials and
+// region come from the standard AWS environment/config chain; S3_ENDPOINT
+// points it at an S3-compatible store such as MinIO.
+type s3Storage struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func newS3Storage(ctx context.Context, envPrefix string) (*s3Storage, error) {
+	bucket := os.Getenv(envPrefix + "S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("%sS3_BUCKET is required for the s3 storage backend", envPrefix)
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if ep := os.Getenv(envPrefix + "S3_ENDPOINT"); ep != "" {
+			o.BaseEndpoint = aws.String(ep)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Storage{
+		client: client,
+		bucket: bucket,
+		prefix: os.Getenv(envPrefix + "S3_PREFIX"),
+	}, nil
+}
+
+func (s *s3Storage) key(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+// Put streams r with the multipart uploader, which handles bodies of unknown
+// length (like a request stream) without buffering them to disk.
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := manager.NewUploader(s.client).Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+		Body:   r,
+	})
+	return err
+}
+
+func (s *s3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// verifySubmitMetadata makes the worker check the size and SHA-256 ingress
+// recorded at submit against the bytes it reads, instead of trusting them.
+var verifySubmitMetadata bool
+
+// uploadCheck hashes the stored bytes as QC reads them, so they can be
+// compared with what ingress saw.
+type uploadCheck struct {
+	r io.Reader
+	h hash.Hash
+}
+
+// newUploadCheck wraps in when verification applies to msg; otherwise it
+// returns in unchanged and a nil check.
+func newUploadCheck(in io.Reader, msg QueueMessage) (io.Reader, *uploadCheck) {
+	if !verifySubmitMetadata || msg.SHA256 == "" {
+		return in, nil
+	}
+	c := &uploadCheck{h: sha256.New()}
+	c.r = io.TeeReader(in, c.h)
+	return c.r, c
+}
+
+// verify reads whatever QC left unread (a gzip trailer, say) and compares
+// the totals with the message. A mismatch means the object changed after
+// submit, which no result should be recorded for.
+func (c *uploadCheck) verify(in *countingReader, msg QueueMessage) error {
+	if _, err := io.Copy(io.Discard, c.r); err != nil {
+		return &qcError{Code: codeStorage, Msg: err.Error()}
+	}
+	if msg.SizeBytes > 0 && in.n != msg.SizeBytes {
+		return &qcError{Code: codeStorage, Msg: fmt.Sprintf("upload is %d bytes, %d at submit", in.n, msg.SizeBytes)}
+	}
+	if sum := hex.EncodeToString(c.h.Sum(nil)); sum != msg.SHA256 {
+		return &qcError{Code: codeStorage, Msg: "upload's SHA-256 differs from the one recorded at submit"}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// Response shapes. v1 is the original {job, qc} with just the core fields,
+// frozen for integrations written against it; v2 is everything the API
+// knows today and grows as features land. Clients pick one with
+// ?api_version=N or Accept: application/vnd.fastq-qc.vN+json, otherwise
+// they get defaultAPIVersion (API_VERSION_DEFAULT).
+const (
+	apiV1     = 1
+	apiV2     = 2
+	apiLatest = apiV2
+)
+
+var defaultAPIVersion = apiLatest
+
+var acceptVersionRe = regexp.MustCompile(`application/vnd\.fastq-qc\.v(\d+)\+json`)
+
+func parseAPIVersion(v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < apiV1 || n > apiLatest {
+		return 0, fmt.Errorf("api_version must be between %d and %d", apiV1, apiLatest)
+	}
+	return n, nil
+}
+
+// requestedVersion reads the version a request asked for; the query
+// parameter wins over Accept.
+func requestedVersion(r *http.Request) (int, error) {
+	if v := r.URL.Query().Get("api_version"); v != "" {
+		return parseAPIVersion(v)
+	}
+	if m := acceptVersionRe.FindStringSubmatch(r.Header.Get("Accept")); m != nil {
+		return parseAPIVersion(m[1])
+	}
+	return defaultAPIVersion, nil
+}
+
+type jobV1 struct {
+	ID          string  `json:"id"`
+	Filename    string  `json:"filename"`
+	Status      string  `json:"status"`
+	Error       *string `json:"error"`
+	SubmittedAt string  `json:"submitted_at"`
+	CompletedAt *string `json:"completed_at"`
+}
+
+type qcV1 struct {
+	Reads         int64    `json:"reads"`
+	AvgReadLength float64  `json:"avg_read_length"`
+	GCContent     *float64 `json:"gc_content"`
+	NContent      *float64 `json:"n_content"`
+	ProcessingMS  int      `json:"processing_ms"`
+}
+
+type respV1 struct {
+	Job *jobV1 `json:"job"`
+	QC  *qcV1  `json:"qc,omitempty"`
+}
+
+func (j *Job) v1() *jobV1 {
+	return &jobV1{ID: j.ID, Filename: j.Filename, Status: j.Status, Error: j.Error, SubmittedAt: j.SubmittedAt, CompletedAt: j.CompletedAt}
+}
+
+// v1 has no partial flag, so running totals are left out rather than
+// passed off as results.
+func (q *QC) v1() *qcV1 {
+	if q == nil || q.Partial {
+		return nil
+	}
+	return &qcV1{Reads: q.Reads, AvgReadLength: q.AvgReadLength, GCContent: q.GCContent, NContent: q.NContent, ProcessingMS: q.ProcessingMS}
+}
+
+// shape converts a response body to the given version.
+func shape(version int, body any) any {
+	if version != apiV1 {
+		return body
+	}
+	switch b := body.(type) {
+	case *Resp:
+		return respV1{Job: b.Job.v1(), QC: b.QC.v1()}
+	case *QC:
+		return b.v1()
+	case []*Job:
+		out := make([]*jobV1, len(b))
+		for i, j := range b {
+			out[i] = j.v1()
+		}
+		return out
+	}
+	return body
+}
+
+// writeVersioned encodes body in the given shape. Bare QC that has no v1
+// shape (partial totals) gets the 404 v2 answers such jobs with, not a
+// null body.
+func writeVersioned(w http.ResponseWriter, version int, body any) {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("API-Version", strconv.Itoa(version))
+	out := shape(version, body)
+	if qc, ok := out.(*qcV1); ok && qc == nil {
+		http.Error(w, "qc results not ready", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var cacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "results_cache_hits_total",
+	Help: "Job lookups served from the in-memory result cache",
+})
+
+func init() {
+	prometheus.MustRegister(cacheHits)
+}
+
+// jobChangedChannel is the Postgres NOTIFY channel the other services
+// signal on, with the job id as payload, when they change a job that
+// results-api may have cached: re-evaluation, analyzer backfills and
+// retries of errored jobs.
+const jobChangedChannel = "qc_job_changed"
+
+// terminal reports whether a job status is settled enough to cache the
+// response. Such jobs still change occasionally (see jobChangedChannel).
+func terminal(status string) bool {
+	return status == "done" || status == "error" || status == "cancelled"
+}
+
+// resultCache is a fixed-size LRU of responses for jobs in a terminal state.
+// In-flight jobs are never added. Entries are dropped when a change to the
+// job is notified, and expire after ttl anyway, which bounds how stale a
+// missed notification can leave one.
+type resultCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	id      string
+	resp    *Resp
+	expires time.Time
+}
+
+func newResultCache(size int, ttl time.Duration) *resultCache {
+	return &resultCache{size: size, ttl: ttl, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// get returns the cached response for id; callers must not modify it.
+func (c *resultCache) get(id string) (*Resp, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(el.Value.(*cacheEntry).expires) {
+		c.order.Remove(el)
+		delete(c.items, id)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	cacheHits.Inc()
+	return el.Value.(*cacheEntry).resp, true
+}
+
+func (c *resultCache) put(id string, resp *Resp) {
+	if c.size <= 0 || !terminal(resp.Job.Status) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[id]; ok {
+		el.Value.(*cacheEntry).resp = resp
+		el.Value.(*cacheEntry).expires = expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[id] = c.order.PushFront(&cacheEntry{id: id, resp: resp, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).id)
+	}
+}
+
+// invalidate drops id's entry, if any.
+func (c *resultCache) invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[id]; ok {
+		c.order.Remove(el)
+		delete(c.items, id)
+	}
+}
+
+// clear drops every entry.
+func (c *resultCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.items = map[string]*list.Element{}
+}
+
+// watchJobChanges LISTENs on jobChangedChannel on a connection of its own
+// and invalidates each notified job. After losing the connection it clears
+// the whole cache, since notifications sent meanwhile are gone, and
+// reconnects.
+func (c *resultCache) watchJobChanges(cfg *pgx.ConnConfig) {
+	for {
+		err := c.listen(cfg)
+		log.Warn().Err(err).Msg("job change listener disconnected; clearing result cache")
+		c.clear()
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (c *resultCache) listen(cfg *pgx.ConnConfig) error {
+	ctx := context.Background()
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	if _, err := conn.Exec(ctx, "LISTEN "+jobChangedChannel); err != nil {
+		return err
+	}
+	// anything cached before LISTEN took effect may have missed a change
+	c.clear()
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		c.invalidate(n.Payload)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// The detection below mirrors the worker's (qc-worker/compression.go); keep
+// the two in step so results-api reads files the way they were QC'd.
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// decompress returns the plain-text stream of r, gunzipping it (including
+// multi-member gzip and BGZF) when it starts with the gzip magic bytes.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	hdr, _ := br.Peek(len(gzipMagic))
+	if !bytes.Equal(hdr, gzipMagic) {
+		return br, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt gzip header: %w", err)
+	}
+	zr.Multistream(true)
+	return zr, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Coverage is an estimated sequencing depth, Bases / GenomeSize, with what
+// it was computed from. Bases is the worker's total_bases; results from
+// before it was stored fall back to reads * avg_read_length. For
+// interleaved paired-end input Reads counts both mates, so Bases covers R1
+// and R2 together. A downsampled job is scaled back up to its input reads,
+// since the file holds every read, not just the sample.
+type Coverage struct {
+	GenomeSize    int64   `json:"genome_size"`
+	Reads         int64   `json:"reads"`
+	AvgReadLength float64 `json:"avg_read_length"`
+	Bases         int64   `json:"bases"`
+	Coverage      float64 `json:"coverage"`
+	Interleaved   bool    `json:"interleaved"`
+	Downsampled   bool    `json:"downsampled"`
+	// Truncated is set when the metrics stopped before the end of the file,
+	// making the estimate a lower bound.
+	Truncated bool `json:"truncated"`
+}
+
+// handleCoverage answers GET /job/{id}/coverage?genome_size=N (bases) with
+// the job's estimated coverage. It reads the stored columns rather than the
+// rounded, cached QC, so the estimate doesn't depend on RESULT_PRECISION.
+func handleCoverage(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseInt(r.URL.Query().Get("genome_size"), 10, 64)
+	if err != nil || size < 1 {
+		http.Error(w, "genome_size must be a positive integer (bases)", http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if _, err := loadJob(id); err != nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	c := Coverage{GenomeSize: size}
+	var inputReads, totalBases *int64
+	var partial bool
+	err = db.QueryRow(`
+SELECT reads, avg_read_length, total_bases, interleaved, input_reads, truncated, partial
+FROM qc_results WHERE job_id=$1`, id).
+		Scan(&c.Reads, &c.AvgReadLength, &totalBases, &c.Interleaved, &inputReads, &c.Truncated, &partial)
+	if err == sql.ErrNoRows || err == nil && partial {
+		http.Error(w, "qc results not ready", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	bases := float64(c.Reads) * c.AvgReadLength
+	if totalBases != nil {
+		bases = float64(*totalBases)
+	}
+	if inputReads != nil {
+		if c.Reads > 0 {
+			bases *= float64(*inputReads) / float64(c.Reads)
+		}
+		c.Reads, c.Downsampled = *inputReads, true
+	}
+	c.Bases = int64(bases + 0.5)
+	c.Coverage = float64(c.Bases) / float64(size)
+	if resultPrecision >= 0 {
+		c.AvgReadLength = roundTo(c.AvgReadLength, resultPrecision)
+		c.Coverage = roundTo(c.Coverage, resultPrecision)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
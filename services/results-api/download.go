@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// handleDownload serves a job's upload exactly as stored, so a gzipped
+// upload comes back gzipped; ranges are byte ranges of the stored file. Both
+// backends give a seekable file (an S3 object fetches the requested range
+// itself), so it goes through http.ServeContent, which answers Range and
+// If-Range requests with 206 for genome browsers and resumable downloaders.
+// A backend that can't seek streams the whole object and advertises no
+// range support. 410 once the upload was deleted, 202 while an archived one
+// is being restored.
+func handleDownload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	f, filename, err := openUpload(r.Context(), id)
+	if err != nil {
+		writeUploadError(w, id, err, http.StatusGone)
+		return
+	}
+	defer f.Close()
+	serveUpload(w, r, id, f, filename)
+}
+
+// serveUpload writes an opened upload as the download response.
+func serveUpload(w http.ResponseWriter, r *http.Request, id string, f io.Reader, filename string) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if rs, ok := f.(io.ReadSeeker); ok {
+		var modTime time.Time
+		switch file := f.(type) {
+		case *os.File:
+			if fi, err := file.Stat(); err == nil {
+				modTime = fi.ModTime()
+			}
+		case *s3Object:
+			modTime = file.modTime
+		}
+		http.ServeContent(w, r, filename, modTime, rs)
+		return
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	if _, err := io.Copy(w, f); err != nil {
+		log.Warn().Err(err).Str("job_id", id).Msg("download: read error")
+	}
+}
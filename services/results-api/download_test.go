@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var downloadData = bytes.Repeat([]byte("@r\nACGT\n+\nIIII\n"), 50) // 750 bytes
+
+// testS3 is an s3Storage talking to h instead of S3.
+func testS3(t *testing.T, h http.HandlerFunc) *s3Storage {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	return &s3Storage{client: client, bucket: "fastq-bucket"}
+}
+
+// fakeS3 serves downloadData for every GetObject, honouring Range like S3.
+func fakeS3(t *testing.T) *s3Storage {
+	return testS3(t, func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Unix(1700000000, 0), bytes.NewReader(downloadData))
+	})
+}
+
+func TestServeUploadRange(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "reads.fastq")
+	if err := os.WriteFile(p, downloadData, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		open func(t *testing.T) io.ReadCloser
+	}{
+		{"local", func(t *testing.T) io.ReadCloser {
+			f, err := os.Open(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f
+		}},
+		{"s3", func(t *testing.T) io.ReadCloser {
+			f, err := fakeS3(t).Open(context.Background(), "reads.fastq")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, c := range []struct {
+				rng, contentRange string
+				want              []byte
+			}{
+				{"bytes=0-99", "bytes 0-99/750", downloadData[:100]},
+				{"bytes=700-", "bytes 700-749/750", downloadData[700:]},
+				{"bytes=-10", "bytes 740-749/750", downloadData[740:]},
+			} {
+				f := tt.open(t)
+				r := httptest.NewRequest("GET", "/job/x/download", nil)
+				r.Header.Set("Range", c.rng)
+				w := httptest.NewRecorder()
+				serveUpload(w, r, "x", f, "reads.fastq")
+				f.Close()
+				if w.Code != http.StatusPartialContent {
+					t.Fatalf("%s: status %d, want 206", c.rng, w.Code)
+				}
+				if got := w.Header().Get("Content-Range"); got != c.contentRange {
+					t.Errorf("%s: Content-Range %q, want %q", c.rng, got, c.contentRange)
+				}
+				if !bytes.Equal(w.Body.Bytes(), c.want) {
+					t.Errorf("%s: body %q, want %q", c.rng, w.Body.Bytes(), c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestServeUploadWhole(t *testing.T) {
+	f, err := fakeS3(t).Open(context.Background(), "reads.fastq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := httptest.NewRecorder()
+	serveUpload(w, httptest.NewRequest("GET", "/job/x/download", nil), "x", f, "reads.fastq")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), downloadData) {
+		t.Fatalf("status %d, %d bytes; want 200 with %d", w.Code, w.Body.Len(), len(downloadData))
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Accept-Ranges %q, want bytes", got)
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "reads.fastq") {
+		t.Errorf("Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestRestoreAlreadyInProgress(t *testing.T) {
+	s := testS3(t, func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["restore"]; !ok {
+			t.Errorf("%s %s, want a RestoreObject call", r.Method, r.URL)
+		}
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, `<Error><Code>RestoreAlreadyInProgress</Code><Message>in progress</Message></Error>`)
+	})
+	if err := s.Restore(context.Background(), "reads.fastq", types.StorageClassGlacier); err != nil {
+		t.Fatalf("restore already in progress: %v", err)
+	}
+}
+
+func TestWriteUploadErrorRestoring(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeUploadError(w, "x", &restoringError{class: types.StorageClassDeepArchive, retryAfter: restoreWait(types.StorageClassDeepArchive)}, http.StatusGone)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "43200" {
+		t.Errorf("Retry-After %q, want 43200", got)
+	}
+}
+
+func TestOpenArchivedObject(t *testing.T) {
+	s := testS3(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `<Error><Code>InvalidObjectState</Code><Message>not valid for the object's storage class</Message><StorageClass>GLACIER</StorageClass></Error>`)
+	})
+	_, err := s.Open(context.Background(), "reads.fastq")
+	var archived *types.InvalidObjectState
+	if !errors.As(err, &archived) {
+		t.Fatalf("err %v, want InvalidObjectState", err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// fastqcVersion is the FastQC release whose fastqc_data.txt layout we
+// follow; parsers such as MultiQC key off the header line.
+const fastqcVersion = "0.12.1"
+
+// handleFastQC renders a finished job's metrics as FastQC's fastqc_data.txt
+// for tools that already parse it. Modules are emitted only when we have
+// their data: Per base sequence quality needs the quality analyzer, whose
+// 5-wide bins make the quartiles interpolated estimates, Per sequence GC
+// content needs gc_distribution and Overrepresented sequences needs the
+// overrepresented analyzer. Pass/warn/fail use FastQC's default limits.
+func handleFastQC(w http.ResponseWriter, r *http.Request) {
+	resp, err := loadResp(mux.Vars(r)["id"])
+	if err != nil {
+		writeLoadError(w, err)
+		return
+	}
+	if resp.QC == nil || resp.QC.Partial {
+		http.Error(w, "qc results not ready", http.StatusNotFound)
+		return
+	}
+	qc := resp.QC
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	fmt.Fprintf(bw, "##FastQC\t%s\n", fastqcVersion)
+
+	beginModule(bw, "Basic Statistics", "pass", "#Measure\tValue")
+	fmt.Fprintf(bw, "Filename\t%s\n", resp.Job.Filename)
+	fmt.Fprintf(bw, "File type\tConventional base calls\n")
+	fmt.Fprintf(bw, "Encoding\tSanger / Illumina 1.9\n")
+	fmt.Fprintf(bw, "Total Sequences\t%d\n", qc.Reads)
+	fmt.Fprintf(bw, "Sequences flagged as poor quality\t0\n")
+	// min/max read lengths aren't tracked; uniform-length runs are exact
+	fmt.Fprintf(bw, "Sequence length\t%d\n", int(math.Round(qc.AvgReadLength)))
+	if qc.GCContent != nil {
+		fmt.Fprintf(bw, "%%GC\t%d\n", int(math.Round(*qc.GCContent*100)))
+	}
+	endModule(bw)
+
+	if len(qc.QualityDist) > 0 {
+		rows := make([][7]float64, len(qc.QualityDist))
+		status := "pass"
+		for i, q := range qc.QualityDist {
+			rows[i] = [7]float64{float64(q.Position), binMean(q.Counts),
+				binQuantile(q.Counts, 0.5), binQuantile(q.Counts, 0.25), binQuantile(q.Counts, 0.75),
+				binQuantile(q.Counts, 0.1), binQuantile(q.Counts, 0.9)}
+			status = worst(status, grade(rows[i][3] < 10 || rows[i][2] < 25, rows[i][3] < 5 || rows[i][2] < 20))
+		}
+		beginModule(bw, "Per base sequence quality", status,
+			"#Base\tMean\tMedian\tLower Quartile\tUpper Quartile\t10th Percentile\t90th Percentile")
+		for _, row := range rows {
+			fmt.Fprintf(bw, "%d\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\n", int(row[0]), row[1], row[2], row[3], row[4], row[5], row[6])
+		}
+		endModule(bw)
+	}
+
+	if len(qc.PerBaseContent) > 0 {
+		status := "pass"
+		for _, b := range qc.PerBaseContent {
+			d := math.Max(math.Abs(b.A-b.T), math.Abs(b.G-b.C))
+			status = worst(status, grade(d > 0.10, d > 0.20))
+		}
+		beginModule(bw, "Per base sequence content", status, "#Base\tG\tA\tT\tC")
+		for _, b := range qc.PerBaseContent {
+			fmt.Fprintf(bw, "%d\t%.2f\t%.2f\t%.2f\t%.2f\n", b.Position, b.G*100, b.A*100, b.T*100, b.C*100)
+		}
+		endModule(bw)
+	}
+
+	if len(qc.GCDistribution) > 0 {
+		counts := make([]float64, 101)
+		for _, b := range qc.GCDistribution {
+			if b.GCPercent >= 0 && b.GCPercent <= 100 {
+				counts[b.GCPercent] = float64(b.Count)
+			}
+		}
+		d := gcDeviation(counts)
+		beginModule(bw, "Per sequence GC content", grade(d > 0.15, d > 0.30), "#GC Content\tCount")
+		for pct, c := range counts {
+			fmt.Fprintf(bw, "%d\t%.1f\n", pct, c)
+		}
+		endModule(bw)
+	}
+
+	var analyses struct {
+		Overrepresented *struct {
+			Sequences []struct {
+				Sequence string  `json:"sequence"`
+				Count    int64   `json:"count"`
+				Fraction float64 `json:"fraction"`
+			} `json:"sequences"`
+		} `json:"overrepresented"`
+	}
+	if qc.Analyses != nil && json.Unmarshal(qc.Analyses, &analyses) == nil && analyses.Overrepresented != nil {
+		seqs := analyses.Overrepresented.Sequences
+		status := "pass"
+		for _, s := range seqs {
+			status = worst(status, grade(s.Fraction > 0.001, s.Fraction > 0.01))
+		}
+		beginModule(bw, "Overrepresented sequences", status, "#Sequence\tCount\tPercentage\tPossible Source")
+		for _, s := range seqs {
+			fmt.Fprintf(bw, "%s\t%d\t%g\tNo Hit\n", s.Sequence, s.Count, s.Fraction*100)
+		}
+		endModule(bw)
+	}
+}
+
+func beginModule(w *bufio.Writer, name, status, columns string) {
+	fmt.Fprintf(w, ">>%s\t%s\n%s\n", name, status, columns)
+}
+
+func endModule(w *bufio.Writer) {
+	fmt.Fprintf(w, ">>END_MODULE\n")
+}
+
+func grade(warn, fail bool) string {
+	switch {
+	case fail:
+		return "fail"
+	case warn:
+		return "warn"
+	}
+	return "pass"
+}
+
+var statusRank = map[string]int{"pass": 0, "warn": 1, "fail": 2}
+
+func worst(a, b string) string {
+	if statusRank[b] > statusRank[a] {
+		return b
+	}
+	return a
+}
+
+// gcDeviation is FastQC's per sequence GC check: the share of reads that
+// lies off a normal distribution with the histogram's own mean and
+// standard deviation.
+func gcDeviation(counts []float64) float64 {
+	var n, sum, sq float64
+	for pct, c := range counts {
+		n += c
+		sum += c * float64(pct)
+		sq += c * float64(pct) * float64(pct)
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / n
+	sd := math.Sqrt(math.Max(sq/n-mean*mean, 0))
+	var off float64
+	for pct, c := range counts {
+		var want float64
+		if sd == 0 {
+			if float64(pct) == math.Round(mean) {
+				want = n
+			}
+		} else {
+			z := (float64(pct) - mean) / sd
+			want = n * math.Exp(-z*z/2) / (sd * math.Sqrt(2*math.Pi))
+		}
+		off += math.Abs(c - want)
+	}
+	return off / n
+}
+
+// binMean estimates the mean quality from the bin midpoints.
+func binMean(counts []int64) float64 {
+	var n, sum float64
+	for i, c := range counts {
+		n += float64(c)
+		sum += float64(c) * (float64(i*qualityBinWidth) + float64(qualityBinWidth-1)/2)
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / n
+}
+
+// binQuantile estimates the p-quantile, interpolating linearly inside the
+// bin where it falls.
+func binQuantile(counts []int64, p float64) float64 {
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	target := p * float64(total)
+	var cum float64
+	for i, c := range counts {
+		if c > 0 && cum+float64(c) >= target {
+			return float64(i*qualityBinWidth) + float64(qualityBinWidth)*(target-cum)/float64(c)
+		}
+		cum += float64(c)
+	}
+	return 0
+}
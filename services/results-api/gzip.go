@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+var gzipMinBytes = 1024
+
+// gzipMiddleware compresses responses for clients sending
+// Accept-Encoding: gzip. Output is buffered until it crosses gzipMinBytes, so
+// small bodies (errors, tiny jobs) go out uncompressed with no overhead.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(enc), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+	passthrough bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	g.status = code
+	// handlers that encode their own output (e.g. promhttp) are left alone,
+	// as are downloads, whose byte ranges refer to the body as sent
+	if g.Header().Get("Content-Encoding") != "" || g.Header().Get("Accept-Ranges") != "" {
+		g.passthrough = true
+		g.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.passthrough {
+		return g.ResponseWriter.Write(p)
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	g.buf.Write(p)
+	if g.buf.Len() < gzipMinBytes {
+		return len(p), nil
+	}
+	if err := g.startGzip(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (g *gzipResponseWriter) startGzip() error {
+	h := g.Header()
+	h.Set("Content-Encoding", "gzip")
+	// the handler's length, if any, described the uncompressed body
+	h.Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.status)
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf.Bytes())
+	g.buf.Reset()
+	return err
+}
+
+// Flush pushes compressed bytes to the client; buffered output below the
+// threshold is committed uncompressed.
+func (g *gzipResponseWriter) Flush() {
+	if !g.passthrough && g.gz == nil {
+		g.flushPlain()
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) flushPlain() {
+	g.passthrough = true
+	g.ResponseWriter.WriteHeader(g.status)
+	g.ResponseWriter.Write(g.buf.Bytes())
+	g.buf.Reset()
+}
+
+func (g *gzipResponseWriter) Close() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	if !g.passthrough {
+		g.flushPlain()
+	}
+	return nil
+}
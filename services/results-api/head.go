@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// store and coldStore are the upload tiers, configured like ingress's
+// (STORAGE_BACKEND, COLD_STORAGE_BACKEND, ...); coldStore is nil when no
+// archive tier is set up.
+var store, coldStore Storage
+
+// maxHeadReads (HEAD_MAX_READS) caps GET /job/{id}/head?reads=.
+var maxHeadReads int
+
+// handleHead streams the first N records of a job's stored file as plain
+// text, decompressed, for spot checks: ?reads=N, default 10. Only as much of
+// the file as those records need is read.
+func handleHead(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if v := r.URL.Query().Get("reads"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 || n > maxHeadReads {
+			http.Error(w, fmt.Sprintf("reads must be an integer between 1 and %d", maxHeadReads), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id := mux.Vars(r)["id"]
+	f, _, err := openUpload(r.Context(), id)
+	if err != nil {
+		writeUploadError(w, id, err, http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	text, err := decompress(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := copyRecords(w, bufio.NewReaderSize(text, 64*1024), n); err != nil && r.Context().Err() != context.Canceled {
+		// headers are already out; all we can do is note it
+		log.Warn().Err(err).Str("job_id", id).Msg("head: read error")
+	}
+}
+
+var (
+	errUploadGone = errors.New("file not found")
+	errNoColdTier = errors.New("file is archived and COLD_STORAGE_BACKEND isn't configured")
+	// jobs submitted with a URL are streamed by the worker and never stored
+	errNotStored = errors.New("job was read from its source URL; no copy is stored")
+)
+
+// restoringError means the upload is in an S3 archive class and a restore
+// has been started; the request should be retried after retryAfter.
+type restoringError struct {
+	class      types.StorageClass
+	retryAfter time.Duration
+}
+
+func (e *restoringError) Error() string {
+	return fmt.Sprintf("file is archived (%s) and being restored; retry later", e.class)
+}
+
+// restoreWait is roughly how long a Standard-tier restore takes.
+func restoreWait(class types.StorageClass) time.Duration {
+	if class == types.StorageClassDeepArchive {
+		return 12 * time.Hour
+	}
+	return 5 * time.Hour
+}
+
+// openUpload opens a job's stored upload, as stored (possibly compressed),
+// from whichever tier holds it, and returns it with the job's filename.
+// Deleted uploads give errUploadGone. An object in an archive storage class
+// can't be read until restored: openUpload starts the restore and returns
+// a *restoringError.
+func openUpload(ctx context.Context, id string) (io.ReadCloser, string, error) {
+	var path sql.NullString
+	var tier, filename string
+	var deleted bool
+	err := db.QueryRow(`SELECT path, storage_tier, filename, file_deleted_at IS NOT NULL FROM jobs WHERE id=$1`, id).
+		Scan(&path, &tier, &filename, &deleted)
+	if err == sql.ErrNoRows {
+		return nil, "", errJobNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if deleted {
+		return nil, "", errUploadGone
+	}
+	if !path.Valid {
+		return nil, "", errNotStored
+	}
+	s := store
+	if tier == "cold" {
+		if s = coldStore; s == nil {
+			return nil, "", errNoColdTier
+		}
+	}
+	f, err := s.Open(ctx, path.String)
+	if err != nil && isNotFound(err) {
+		return nil, "", errUploadGone
+	}
+	var archived *types.InvalidObjectState
+	if s3s, ok := s.(*s3Storage); ok && errors.As(err, &archived) {
+		if err := s3s.Restore(ctx, path.String, archived.StorageClass); err != nil {
+			return nil, "", fmt.Errorf("restore archived object: %w", err)
+		}
+		log.Info().Str("job_id", id).Str("storage_class", string(archived.StorageClass)).Msg("restore of archived upload started")
+		return nil, "", &restoringError{class: archived.StorageClass, retryAfter: restoreWait(archived.StorageClass)}
+	}
+	return f, filename, err
+}
+
+// writeUploadError answers an openUpload failure; goneStatus is what a
+// deleted upload gets.
+func writeUploadError(w http.ResponseWriter, id string, err error, goneStatus int) {
+	var restoring *restoringError
+	if errors.As(err, &restoring) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(restoring.retryAfter.Seconds())))
+		http.Error(w, err.Error(), http.StatusAccepted)
+		return
+	}
+	switch err {
+	case errJobNotFound:
+		http.Error(w, "file not found", http.StatusNotFound)
+	case errUploadGone:
+		http.Error(w, err.Error(), goneStatus)
+	case errNoColdTier, errNotStored:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Error().Err(err).Str("job_id", id).Msg("storage open error")
+		http.Error(w, "storage error", http.StatusInternalServerError)
+	}
+}
+
+// copyRecords writes the first n FASTQ records (4 lines each) or FASTA
+// records ('>' header plus its sequence lines) from br to w. Lines are copied
+// in buffer-sized pieces, so an over-long line never sits in memory whole.
+func copyRecords(w io.Writer, br *bufio.Reader, n int) error {
+	fasta := false
+	if b, err := br.Peek(1); err == nil {
+		fasta = b[0] == '>'
+	}
+	records, lines := 0, 0
+	lineStart := true
+	for {
+		if lineStart && fasta {
+			if b, err := br.Peek(1); err == nil && b[0] == '>' {
+				if records == n {
+					return nil
+				}
+				records++
+			}
+		}
+		chunk, err := br.ReadSlice('\n')
+		if _, werr := w.Write(chunk); werr != nil {
+			return werr
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			lineStart = false
+			continue
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		lineStart = true
+		if !fasta {
+			if lines++; lines == 4*n {
+				return nil
+			}
+		}
+	}
+}
+
+// isNotFound reports whether a storage Open failed because the object isn't
+// there (local file or S3 key).
+func isNotFound(err error) bool {
+	var noKey *types.NoSuchKey
+	return errors.Is(err, fs.ErrNotExist) || errors.As(err, &noKey)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var requestsShed = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "results_requests_shed_total",
+	Help: "Requests answered 503 because DB_MAX_CONCURRENT_READS requests were already running",
+})
+
+func init() {
+	prometheus.MustRegister(requestsShed)
+}
+
+// readLimiter caps how many requests may be reading from the database at
+// once (DB_MAX_CONCURRENT_READS). A request that finds every slot taken
+// waits up to DB_QUEUE_TIMEOUT for one and is then turned away with 503, so
+// a burst of dashboard polls is shed quickly instead of queueing on the
+// pool until everything times out. Cache hits take a slot too, but only
+// for as long as it takes to serve them.
+type readLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// unlimitedPaths never touch the database. Downloads are exempt too: they
+// make one quick query and then spend their time streaming from storage.
+var unlimitedPaths = map[string]bool{"/metrics": true, "/version": true}
+
+func limited(path string) bool {
+	return !unlimitedPaths[path] && !strings.HasSuffix(path, "/download")
+}
+
+func newReadLimiter(n int, wait time.Duration) *readLimiter {
+	return &readLimiter{slots: make(chan struct{}, n), wait: wait}
+}
+
+func (l *readLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limited(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			t := time.NewTimer(l.wait)
+			select {
+			case l.slots <- struct{}{}:
+				t.Stop()
+			case <-t.C:
+				requestsShed.Inc()
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many concurrent requests, try again shortly", http.StatusServiceUnavailable)
+				return
+			case <-r.Context().Done():
+				t.Stop()
+				return
+			}
+		}
+		defer func() { <-l.slots }()
+		next.ServeHTTP(w, r)
+	})
+}
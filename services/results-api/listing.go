@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// handleListJobs lists the most recent jobs, optionally filtered by the
+// sample metadata given on submit: every meta.<key>=<value> parameter must
+// match exactly (values are compared as strings), so
+// GET /jobs?meta.project=ABC&meta.operator=jd finds ABC's jobs run by jd.
+// The filter is a single metadata @> containment test, which the GIN index
+// on jobs.metadata serves; status= narrows further.
+//
+// For triage, qc_pass=true|false (any, the default, doesn't filter) and
+// failed_check=<name> select on the finished results, so
+// GET /jobs?qc_pass=false&failed_check=n_content_high is a worklist of samples
+// that failed on N content. Jobs without final results never match either.
+func handleListJobs(w http.ResponseWriter, r *http.Request) {
+	version, err := requestedVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	limit := defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxListLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	meta := map[string]string{}
+	for k := range q {
+		if key, ok := strings.CutPrefix(k, "meta."); ok {
+			if key == "" || len(q[k]) > 1 {
+				http.Error(w, "each meta.<key> must name a key and be given once", http.StatusBadRequest)
+				return
+			}
+			meta[key] = q.Get(k)
+		}
+	}
+
+	where := []string{}
+	args := []any{}
+	if len(meta) > 0 {
+		b, _ := json.Marshal(meta)
+		args = append(args, string(b))
+		where = append(where, "metadata @> $"+strconv.Itoa(len(args))+"::jsonb")
+	}
+	if status := q.Get("status"); status != "" {
+		args = append(args, status)
+		where = append(where, "status = $"+strconv.Itoa(len(args)))
+	}
+	results := []string{}
+	switch v := q.Get("qc_pass"); v {
+	case "", "any":
+	case "true", "false":
+		args = append(args, v == "true")
+		results = append(results, "qc_pass = $"+strconv.Itoa(len(args)))
+	default:
+		http.Error(w, "qc_pass must be true, false or any", http.StatusBadRequest)
+		return
+	}
+	if check := q.Get("failed_check"); check != "" {
+		args = append(args, check)
+		results = append(results, "$"+strconv.Itoa(len(args))+" = ANY(failed_checks)")
+	}
+	if len(results) > 0 {
+		where = append(where, "id IN (SELECT job_id FROM qc_results WHERE NOT partial AND "+strings.Join(results, " AND ")+")")
+	}
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, limit)
+	query += ` ORDER BY submitted_at DESC LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	writeVersioned(w, version, jobs)
+}
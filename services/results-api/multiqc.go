@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// MultiQCContent is a MultiQC custom-content document: one table section,
+// named by ID, whose Data has a row of flat metrics per sample. Saved under
+// a name ending in _mqc.json, MultiQC picks it up without any config.
+type MultiQCContent struct {
+	ID          string                    `json:"id"`
+	SectionName string                    `json:"section_name"`
+	Description string                    `json:"description"`
+	PlotType    string                    `json:"plot_type"`
+	PConfig     map[string]string         `json:"pconfig"`
+	Data        map[string]map[string]any `json:"data"`
+}
+
+// handleMultiQC answers GET /job/{id}/multiqc.json with a finished job's
+// headline metrics, and the numeric ones from each analyzer summary as
+// <analyzer>_<key>, in MultiQC's custom-content shape. Metrics the job
+// doesn't have (GC for count-only runs, ...) are left out rather than null.
+// ?plot_type=generalstats puts them in MultiQC's General Statistics table
+// instead of a section of their own.
+func handleMultiQC(w http.ResponseWriter, r *http.Request) {
+	plotType := r.URL.Query().Get("plot_type")
+	if plotType == "" {
+		plotType = "table"
+	}
+	if plotType != "table" && plotType != "generalstats" {
+		http.Error(w, "plot_type must be table or generalstats", http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	resp, err := loadResp(id)
+	if err != nil {
+		writeLoadError(w, err)
+		return
+	}
+	if resp.QC == nil || resp.QC.Partial {
+		http.Error(w, "qc results not ready", http.StatusNotFound)
+		return
+	}
+	qc := resp.QC
+
+	row := map[string]any{
+		"reads":           qc.Reads,
+		"avg_read_length": qc.AvgReadLength,
+	}
+	if qc.TotalBases != nil {
+		row["total_bases"] = *qc.TotalBases
+	}
+	if qc.ReadN50 != nil {
+		row["read_n50"] = *qc.ReadN50
+	}
+	if qc.GCContent != nil {
+		row["gc_content"] = *qc.GCContent
+	}
+	if qc.NContent != nil {
+		row["n_content"] = *qc.NContent
+	}
+	if qc.QCPass != nil {
+		row["qc_pass"] = "fail"
+		if *qc.QCPass {
+			row["qc_pass"] = "pass"
+		}
+	}
+	if qc.ShortReads != nil {
+		row["short_read_count"] = *qc.ShortReads
+	}
+	if qc.InvalidChars != nil {
+		row["invalid_char_count"] = *qc.InvalidChars
+	}
+	var analyses map[string]map[string]any
+	if qc.Analyses != nil && json.Unmarshal(qc.Analyses, &analyses) == nil {
+		for name, summary := range analyses {
+			for k, v := range summary {
+				if n, ok := v.(float64); ok {
+					row[name+"_"+k] = n
+				}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": id + "_mqc.json"}))
+	json.NewEncoder(w).Encode(MultiQCContent{
+		ID:          "fastq_qc",
+		SectionName: "fastq-qc",
+		Description: "Metrics computed by the fastq-qc service.",
+		PlotType:    plotType,
+		PConfig:     map[string]string{"id": "fastq_qc_table", "title": "fastq-qc"},
+		Data:        map[string]map[string]any{multiQCSample(resp.Job): row},
+	})
+}
+
+// multiQCSample names a job's row: the sample_id from its metadata if it
+// has one, otherwise the uploaded file name without its FASTQ/FASTA and
+// compression extensions, as MultiQC would clean it, or the job id.
+func multiQCSample(job *Job) string {
+	var meta struct {
+		SampleID string `json:"sample_id"`
+	}
+	if job.Metadata != nil && json.Unmarshal(job.Metadata, &meta) == nil && meta.SampleID != "" {
+		return meta.SampleID
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(job.Filename, ".gz"), ".bgz")
+	for _, ext := range []string{".fastq", ".fq", ".fasta", ".fa"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	if name == "" {
+		return job.ID
+	}
+	return name
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Storage is where uploaded FASTQ files live. Keys are what ingress records
+// in jobs.path and sends in QueueMessage.Path; the worker opens the same key
+// through its own Storage.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// newStorage picks the backend from STORAGE_BACKEND (local or s3).
+func newStorage(ctx context.Context) (Storage, error) {
+	return newTierStorage(ctx, "")
+}
+
+// newColdStorage is the archive tier, configured like the main one with
+// every variable prefixed COLD_ (COLD_STORAGE_BACKEND, COLD_S3_BUCKET, ...).
+// It returns nil when COLD_STORAGE_BACKEND is unset.
+func newColdStorage(ctx context.Context) (Storage, error) {
+	if os.Getenv("COLD_STORAGE_BACKEND") == "" {
+		return nil, nil
+	}
+	return newTierStorage(ctx, "COLD_")
+}
+
+func newTierStorage(ctx context.Context, prefix string) (Storage, error) {
+	switch backend := env(prefix+"STORAGE_BACKEND", "local"); backend {
+	case "local":
+		if prefix == "" {
+			return &localStorage{root: env("UPLOAD_DIR", "/data/uploads")}, nil
+		}
+		return newColdLocalStorage(prefix)
+	case "s3":
+		return newS3Storage(ctx, prefix)
+	default:
+		return nil, fmt.Errorf("unknown %sSTORAGE_BACKEND %q", prefix, backend)
+	}
+}
+
+// newColdLocalStorage is a local cold tier. Its directory has no default and
+// must not be the hot tier's: archiving the same directory onto itself
+// would truncate each file while copying it and then delete the only copy.
+func newColdLocalStorage(prefix string) (Storage, error) {
+	root := os.Getenv(prefix + "UPLOAD_DIR")
+	if root == "" {
+		return nil, fmt.Errorf("%sUPLOAD_DIR is required when %sSTORAGE_BACKEND is local", prefix, prefix)
+	}
+	if env("STORAGE_BACKEND", "local") == "local" {
+		same, err := sameDir(root, env("UPLOAD_DIR", "/data/uploads"))
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			return nil, fmt.Errorf("%sUPLOAD_DIR must differ from UPLOAD_DIR", prefix)
+		}
+	}
+	return &localStorage{root: root}, nil
+}
+
+// sameDir reports whether a and b name the same directory, following
+// symlinks where the paths exist.
+func sameDir(a, b string) (bool, error) {
+	resolve := func(p string) (string, error) {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return "", err
+		}
+		if r, err := filepath.EvalSymlinks(p); err == nil {
+			p = r
+		}
+		return p, nil
+	}
+	ra, err := resolve(a)
+	if err != nil {
+		return false, err
+	}
+	rb, err := resolve(b)
+	if err != nil {
+		return false, err
+	}
+	return ra == rb, nil
+}
+
+// localStorage keeps files under root. Keys are relative to root; absolute
+// paths inside root (recorded by older versions) are accepted too.
+type localStorage struct {
+	root string
+}
+
+func (l *localStorage) path(key string) (string, error) {
+	p := key
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(l.root, key)
+	}
+	p = filepath.Clean(p)
+	rel, err := filepath.Rel(filepath.Clean(l.root), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("storage key %q escapes %s", key, l.root)
+	}
+	return p, nil
+}
+
+// Put writes the file and fsyncs it and its directory, so a job is never
+// enqueued for bytes that a crash could lose.
+func (l *localStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := out.ReadFrom(r); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(p))
+}
+
+func (l *localStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (l *localStorage) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// s3Storage stores objects under S3_PREFIX in S3_BUCKET. Credentials and
+// region come from the standard AWS environment/config chain; S3_ENDPOINT
+// points it at an S3-compatible store such as MinIO. S3_STORAGE_CLASS (e.g.
+// GLACIER_IR for the cold tier) applies to new objects.
+type s3Storage struct {
+	client       *s3.Client
+	bucket       string
+	prefix       string
+	storageClass types.StorageClass
+}
+
+func newS3Storage(ctx context.Context, envPrefix string) (*s3Storage, error) {
+	bucket := os.Getenv(envPrefix + "S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("%sS3_BUCKET is required for the s3 storage backend", envPrefix)
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if ep := os.Getenv(envPrefix + "S3_ENDPOINT"); ep != "" {
+			o.BaseEndpoint = aws.String(ep)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Storage{
+		client:       client,
+		bucket:       bucket,
+		prefix:       os.Getenv(envPrefix + "S3_PREFIX"),
+		storageClass: types.StorageClass(os.Getenv(envPrefix + "S3_STORAGE_CLASS")),
+	}, nil
+}
+
+func (s *s3Storage) key(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+// Put streams r with the multipart uploader, which handles bodies of unknown
+// length (like a request stream) without buffering them to disk.
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := manager.NewUploader(s.client).Upload(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(s.key(key)),
+		Body:         r,
+		StorageClass: s.storageClass,
+	})
+	return err
+}
+
+// Open starts reading the whole object. The result is an *s3Object, which
+// can also seek, so downloads can serve Range requests from it.
+func (s *s3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	o := &s3Object{ctx: ctx, s: s, key: key, body: out.Body, size: aws.ToInt64(out.ContentLength)}
+	if out.LastModified != nil {
+		o.modTime = *out.LastModified
+	}
+	return o, nil
+}
+
+// s3Object reads an object from the current offset. Seeking doesn't touch
+// S3; the next Read after moving away from the open body's position closes
+// it and issues a ranged GetObject from the new offset instead.
+type s3Object struct {
+	ctx     context.Context
+	s       *s3Storage
+	key     string
+	body    io.ReadCloser
+	bodyOff int64 // offset the open body reads from next
+	off     int64
+	size    int64
+	modTime time.Time
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.body != nil && o.bodyOff != o.off {
+		o.body.Close()
+		o.body = nil
+	}
+	if o.off >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		out, err := o.s.client.GetObject(o.ctx, &s3.GetObjectInput{
+			Bucket: aws.String(o.s.bucket),
+			Key:    aws.String(o.s.key(o.key)),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", o.off)),
+		})
+		if err != nil {
+			return 0, err
+		}
+		o.body, o.bodyOff = out.Body, o.off
+	}
+	n, err := o.body.Read(p)
+	o.off += int64(n)
+	o.bodyOff = o.off
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.off
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, fmt.Errorf("seek: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek: negative offset")
+	}
+	o.off = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	return err
+}
+
+// restoreDays is how long a copy restored from an archive class (GLACIER,
+// DEEP_ARCHIVE) stays readable before S3 drops it again.
+const restoreDays = 7
+
+// Restore asks S3 to bring an archived object back so Open can read it.
+// Restoring takes hours; a restore already under way counts as started.
+// Intelligent-Tiering archive tiers move the object back themselves and
+// take no Days.
+func (s *s3Storage) Restore(ctx context.Context, key string, class types.StorageClass) error {
+	req := &types.RestoreRequest{}
+	if class != types.StorageClassIntelligentTiering {
+		req.Days = aws.Int32(restoreDays)
+		req.GlacierJobParameters = &types.GlacierJobParameters{Tier: types.TierStandard}
+	}
+	_, err := s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(s.bucket),
+		Key:            aws.String(s.key(key)),
+		RestoreRequest: req,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// trendBuckets are the date_trunc units GET /trends/gc accepts.
+var trendBuckets = map[string]bool{"hour": true, "day": true, "week": true}
+
+// GCPoint is one time bucket of a GC trend: the mean GC content of the done
+// jobs submitted in [bucket, bucket + 1 unit).
+type GCPoint struct {
+	Bucket string  `json:"bucket"`
+	Jobs   int64   `json:"jobs"`
+	AvgGC  float64 `json:"avg_gc"`
+}
+
+// handleGCTrend answers GET /trends/gc?from=&to=&bucket= with the average GC
+// content of done jobs per hour, day or week (UTC), oldest first. Empty
+// buckets are left out; count-only jobs have no GC and aren't counted.
+func handleGCTrend(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	bucket := q.Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	if !trendBuckets[bucket] {
+		http.Error(w, "bucket must be hour, day or week", http.StatusBadRequest)
+		return
+	}
+	to := time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		t, err := parseTrendTime(v, true)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -30)
+	if v := q.Get("from"); v != "" {
+		t, err := parseTrendTime(v, false)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+SELECT to_char(date_trunc($1, j.submitted_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD"T"HH24:MI:SS"Z"'),
+       count(*), avg(q.gc_content)
+FROM jobs j JOIN qc_results q ON q.job_id = j.id
+WHERE j.status='done' AND q.gc_content IS NOT NULL
+  AND j.submitted_at >= $2 AND j.submitted_at < $3
+GROUP BY 1 ORDER BY 1`, bucket, from, to)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	points := []GCPoint{}
+	for rows.Next() {
+		var p GCPoint
+		if err := rows.Scan(&p.Bucket, &p.Jobs, &p.AvgGC); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if resultPrecision >= 0 {
+			p.AvgGC = roundTo(p.AvgGC, resultPrecision)
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(points)
+}
+
+// parseTrendTime accepts RFC 3339 or a bare YYYY-MM-DD (UTC midnight). A bare
+// date used as the end of the range includes that whole day.
+func parseTrendTime(v string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want RFC 3339 or YYYY-MM-DD, got %q", v)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}